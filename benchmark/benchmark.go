@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
@@ -13,18 +14,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/erikbayerlein/mult-protocol-clients/client"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/auth"
-	jc "github.com/erikbayerlein/mult-protocol-clients/json"
-	pb "github.com/erikbayerlein/mult-protocol-clients/proto"
-	sc "github.com/erikbayerlein/mult-protocol-clients/strings"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/trace"
+	_ "github.com/erikbayerlein/mult-protocol-clients/json"
+	_ "github.com/erikbayerlein/mult-protocol-clients/proto"
+	_ "github.com/erikbayerlein/mult-protocol-clients/strings"
 )
 
 const (
-	host           = "3.88.99.255"
-	string_port    = 8080
-	json_port      = 8081
-	protobuff_port = 8082
-	studentID      = 537606
+	host      = "3.88.99.255"
+	studentID = 537606
 )
 
 type BenchmarkResult struct {
@@ -36,6 +37,8 @@ type BenchmarkResult struct {
 	MemAllocBefore uint64
 	MemAllocAfter  uint64
 	MemAllocDelta  uint64
+	BytesSent      uint64
+	BytesReceived  uint64
 	Success        bool
 	Error          string
 }
@@ -50,6 +53,7 @@ type BenchmarkStats struct {
 	MaxTimeMs   float64
 	StdDevMs    float64
 	MemAllocAvg uint64
+	BytesAvg    uint64
 	SuccessRate float64
 }
 
@@ -57,8 +61,26 @@ var (
 	iterations = flag.Int("iterations", 5, "Number of iterations per operation")
 	outputDir  = flag.String("output", "./benchmark_results", "Output directory for results")
 	verbose    = flag.Bool("verbose", false, "Verbose output")
+	timeout    = flag.Duration("timeout", 10*time.Second, "Timeout for each operation")
+	recordPath = flag.String("record", "", "Append wire traces to this JSONL file (tokens redacted)")
+	replayPath = flag.String("replay", "", "Serve responses from this recorded JSONL trace instead of the network")
+	compress   = flag.String("compression", "", "Also benchmark the proto client with compression negotiated (gzip or flate); servers without it stay uncompressed")
+	rate       = flag.Float64("rate", 0, "Limit requests per second to each server so long runs don't flood it (0 = unlimited)")
+	traceFile  = flag.String("trace-file", "", "Append OTLP-JSON spans for every operation to this file")
+	metrics    = flag.String("metrics-addr", "", "Serve transport metrics in Prometheus format at http://<addr>/metrics while running")
+
+	endpoints = endpointFlags()
 )
 
+// endpointFlags adds -<protocol>-endpoint for every registered protocol.
+func endpointFlags() map[string]*string {
+	out := make(map[string]*string)
+	for _, p := range client.Protocols() {
+		out[p.Name] = flag.String(p.Name+"-endpoint", "", "Reach the "+p.Title+" server at unix:///path instead of TCP")
+	}
+	return out
+}
+
 func main() {
 	flag.Parse()
 
@@ -71,17 +93,79 @@ func main() {
 	fmt.Printf("Iterations: %d\n", *iterations)
 	fmt.Printf("Output directory: %s\n\n", *outputDir)
 
+	if *metrics != "" {
+		if _, err := tcp.ServeMetrics(*metrics, tcp.Default); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting metrics server: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Serving metrics at http://%s/metrics\n", *metrics)
+	}
+
+	tcp.SetDefaultConfig(transportConfig())
+
+	var transport tcp.Transport = tcp.Default
+	if *replayPath != "" {
+		f, err := os.Open(*replayPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening replay file: %v\n", err)
+			os.Exit(1)
+		}
+		rp, err := tcp.NewReplay(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading replay file: %v\n", err)
+			os.Exit(1)
+		}
+		transport = rp
+		fmt.Printf("Replaying %d recorded exchanges from %s\n", rp.Len(), *replayPath)
+	}
+	if *traceFile != "" {
+		f, err := os.OpenFile(*traceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening trace file: %v\n", err)
+			os.Exit(1)
+		}
+		_ = trace.SetExporter(trace.NewFileExporter(f, "mult-protocol-benchmark"))
+		defer trace.SetExporter(nil)
+		tcp.AddHooks(trace.TransportHooks())
+		fmt.Printf("Writing trace spans to %s\n", *traceFile)
+	}
+	if *recordPath != "" {
+		f, err := os.OpenFile(*recordPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening record file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		transport = tcp.NewRecorder(transport, f)
+		fmt.Printf("Recording wire traffic to %s\n", *recordPath)
+	}
+
 	// Initialize clients
-	stringClient := sc.StringClient{Host: host, Port: string_port}
-	jsonClient := jc.JsonClient{Host: host, Port: json_port}
-	protoClient := pb.ProtobufClient{Host: host, Port: protobuff_port}
+	clients := make(map[string]client.Client)
+	for _, p := range client.Protocols() {
+		c, err := client.New(p.Name, client.Options{Host: host, Transport: transport, Endpoint: *endpoints[p.Name]})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s client: %v\n", p.Name, err)
+			os.Exit(1)
+		}
+		clients[p.Name] = c
+	}
 
 	var results []BenchmarkResult
 
 	// Test each client
-	results = append(results, benchmarkClient("string", &stringClient)...)
-	results = append(results, benchmarkClient("json", &jsonClient)...)
-	results = append(results, benchmarkClient("proto", &protoClient)...)
+	for _, name := range client.Names() {
+		results = append(results, benchmarkClient(name, clients[name])...)
+	}
+
+	if protoClient, ok := clients["proto"]; ok && *compress != "" {
+		cfg := transportConfig()
+		cfg.Compression = *compress
+		h, p := protoClient.Target()
+		tcp.Configure(h, p, cfg)
+		results = append(results, benchmarkClient("proto-"+*compress, protoClient)...)
+	}
 
 	// Save results
 	if err := saveResultsToCSV(results); err != nil {
@@ -102,14 +186,14 @@ func main() {
 	fmt.Println("✓ Benchmarks completed successfully")
 }
 
-func benchmarkClient(clientName string, client interface{}) []BenchmarkResult {
+func benchmarkClient(clientName string, c client.Client) []BenchmarkResult {
 	var results []BenchmarkResult
 
 	fmt.Printf("=== Benchmarking %s client ===\n", strings.ToUpper(clientName))
 
 	// Get token
 	token := ""
-	authResults := benchmarkAuth(clientName, client)
+	authResults := benchmarkAuth(clientName, c)
 	results = append(results, authResults...)
 
 	rec, err := auth.LoadToken()
@@ -143,22 +227,22 @@ func benchmarkClient(clientName string, client interface{}) []BenchmarkResult {
 			runtime.GC()
 			memBefore := getMemoryStats()
 			result.MemAllocBefore = memBefore.Alloc
+			trafficBefore := trafficOf(c)
 
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			ctx, span := trace.Start(ctx, "benchmark."+clientName,
+				trace.String("protocol", clientName), trace.String("operation", op.name), trace.Int("iteration", i+1))
 			start := time.Now()
-			var err error
-
-			switch c := client.(type) {
-			case *sc.StringClient:
-				_, err = c.DoOperation(op.name, token, parseStringParams(op.name, op.args))
-			case *jc.JsonClient:
-				err = c.Run(op.name, op.args)
-			case *pb.ProtobufClient:
-				err = c.Run(op.name, op.args)
-			}
+			_, err := client.Do(ctx, c, op.name, op.args)
 
 			duration := time.Since(start)
+			span.End(err)
+			cancel()
 			memAfter := getMemoryStats()
 			result.MemAllocAfter = memAfter.Alloc
+			trafficAfter := trafficOf(c)
+			result.BytesSent = trafficAfter.BytesSent - trafficBefore.BytesSent
+			result.BytesReceived = trafficAfter.BytesReceived - trafficBefore.BytesReceived
 			result.Duration = duration
 			result.DurationMs = duration.Seconds() * 1000
 
@@ -195,22 +279,19 @@ func benchmarkClient(clientName string, client interface{}) []BenchmarkResult {
 		runtime.GC()
 		memBefore := getMemoryStats()
 		result.MemAllocBefore = memBefore.Alloc
+		trafficBefore := trafficOf(c)
 
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 		start := time.Now()
-		var err error
-
-		switch c := client.(type) {
-		case *sc.StringClient:
-			err = c.Logout(token)
-		case *jc.JsonClient:
-			err = c.Logout(token)
-		case *pb.ProtobufClient:
-			err = c.Logout(token)
-		}
+		err := c.Logout(ctx, token)
 
 		duration := time.Since(start)
+		cancel()
 		memAfter := getMemoryStats()
 		result.MemAllocAfter = memAfter.Alloc
+		trafficAfter := trafficOf(c)
+		result.BytesSent = trafficAfter.BytesSent - trafficBefore.BytesSent
+		result.BytesReceived = trafficAfter.BytesReceived - trafficBefore.BytesReceived
 		result.Duration = duration
 		result.DurationMs = duration.Seconds() * 1000
 
@@ -235,7 +316,7 @@ func benchmarkClient(clientName string, client interface{}) []BenchmarkResult {
 
 		// After first logout, login again for next iterations
 		if i < *iterations-1 {
-			if err := benchmarkLogin(client); err != nil {
+			if err := benchmarkLogin(c); err != nil {
 				fmt.Printf("Error re-logging in: %v\n", err)
 				break
 			}
@@ -248,7 +329,7 @@ func benchmarkClient(clientName string, client interface{}) []BenchmarkResult {
 	return results
 }
 
-func benchmarkAuth(clientName string, client interface{}) []BenchmarkResult {
+func benchmarkAuth(clientName string, c client.Client) []BenchmarkResult {
 	var results []BenchmarkResult
 
 	fmt.Printf("\n  Testing auth...\n")
@@ -263,22 +344,19 @@ func benchmarkAuth(clientName string, client interface{}) []BenchmarkResult {
 		runtime.GC()
 		memBefore := getMemoryStats()
 		result.MemAllocBefore = memBefore.Alloc
+		trafficBefore := trafficOf(c)
 
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 		start := time.Now()
-		var err error
-
-		switch c := client.(type) {
-		case *sc.StringClient:
-			err = c.Login(studentID)
-		case *jc.JsonClient:
-			err = c.Login(studentID)
-		case *pb.ProtobufClient:
-			err = c.Login(studentID)
-		}
+		err := c.Login(ctx, studentID)
 
 		duration := time.Since(start)
+		cancel()
 		memAfter := getMemoryStats()
 		result.MemAllocAfter = memAfter.Alloc
+		trafficAfter := trafficOf(c)
+		result.BytesSent = trafficAfter.BytesSent - trafficBefore.BytesSent
+		result.BytesReceived = trafficAfter.BytesReceived - trafficBefore.BytesReceived
 		result.Duration = duration
 		result.DurationMs = duration.Seconds() * 1000
 
@@ -305,47 +383,21 @@ func benchmarkAuth(clientName string, client interface{}) []BenchmarkResult {
 	return results
 }
 
-func benchmarkLogin(client interface{}) error {
-	switch c := client.(type) {
-	case *sc.StringClient:
-		return c.Login(studentID)
-	case *jc.JsonClient:
-		return c.Login(studentID)
-	case *pb.ProtobufClient:
-		return c.Login(studentID)
-	}
-	return fmt.Errorf("unknown client type")
-}
+func benchmarkLogin(c client.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
 
-func parseStringParams(operation string, args []string) map[string]interface{} {
-	params := make(map[string]interface{})
+	return c.Login(ctx, studentID)
+}
 
-	switch operation {
-	case "echo":
-		if len(args) > 0 {
-			params["mensagem"] = strings.Join(args, " ")
-		}
-	case "soma":
-		if len(args) > 0 {
-			parts := strings.Split(args[0], ",")
-			ints := make([]int, 0, len(parts))
-			for _, p := range parts {
-				n, _ := strconv.Atoi(strings.TrimSpace(p))
-				ints = append(ints, n)
-			}
-			params["nums"] = ints
-		}
-	case "historico":
-		if len(args) > 0 {
-			if v, err := strconv.Atoi(args[0]); err == nil {
-				params["limite"] = v
-			}
-		}
-	case "status":
-		params["detalhado"] = true
-	}
+func transportConfig() tcp.Config {
+	cfg := tcp.DefaultConfig()
+	cfg.RateLimit = tcp.RateLimit{Rate: *rate, Burst: 1}
+	return cfg
+}
 
-	return params
+func trafficOf(c client.Client) tcp.Traffic {
+	return tcp.Default.Traffic(c.Target())
 }
 
 func parseParams(operation string, args []string) map[string]interface{} {
@@ -383,7 +435,7 @@ func saveResultsToCSV(results []BenchmarkResult) error {
 	defer writer.Flush()
 
 	// Write header
-	header := []string{"Client", "Operation", "Iteration", "DurationMs", "MemAllocBefore", "MemAllocAfter", "MemAllocDelta", "Success", "Error"}
+	header := []string{"Client", "Operation", "Iteration", "DurationMs", "MemAllocBefore", "MemAllocAfter", "MemAllocDelta", "BytesSent", "BytesReceived", "Success", "Error"}
 	if err := writer.Write(header); err != nil {
 		return err
 	}
@@ -398,6 +450,8 @@ func saveResultsToCSV(results []BenchmarkResult) error {
 			strconv.FormatUint(result.MemAllocBefore, 10),
 			strconv.FormatUint(result.MemAllocAfter, 10),
 			strconv.FormatUint(result.MemAllocDelta, 10),
+			strconv.FormatUint(result.BytesSent, 10),
+			strconv.FormatUint(result.BytesReceived, 10),
 			strconv.FormatBool(result.Success),
 			result.Error,
 		}
@@ -437,6 +491,7 @@ func saveResultsToJSON(results []BenchmarkResult) error {
 func calculateStats(results []BenchmarkResult) []BenchmarkStats {
 	statsMap := make(map[string][]float64)
 	memAllocMap := make(map[string][]uint64)
+	bytesMap := make(map[string]uint64)
 	successMap := make(map[string]int)
 	countMap := make(map[string]int)
 
@@ -445,6 +500,7 @@ func calculateStats(results []BenchmarkResult) []BenchmarkStats {
 		key := result.Client + "_" + result.Operation
 		statsMap[key] = append(statsMap[key], result.DurationMs)
 		memAllocMap[key] = append(memAllocMap[key], result.MemAllocDelta)
+		bytesMap[key] += result.BytesSent + result.BytesReceived
 		countMap[key]++
 		if result.Success {
 			successMap[key]++
@@ -502,6 +558,7 @@ func calculateStats(results []BenchmarkResult) []BenchmarkStats {
 			MaxTimeMs:   maxVal,
 			StdDevMs:    stdDev,
 			MemAllocAvg: memAllocAvg,
+			BytesAvg:    bytesMap[key] / uint64(countMap[key]),
 			SuccessRate: successRate,
 		})
 	}
@@ -526,19 +583,20 @@ func printStats(stats []BenchmarkStats) {
 		if stat.Client != currentClient {
 			currentClient = stat.Client
 			fmt.Printf("\n--- %s CLIENT ---\n", strings.ToUpper(stat.Client))
-			fmt.Printf("%-15s | %-10s | %-10s | %-10s | %-10s | %-12s | %-10s\n",
-				"Operation", "Avg (ms)", "Min (ms)", "Max (ms)", "Success", "MemAlloc(B)", "Count")
+			fmt.Printf("%-15s | %-10s | %-10s | %-10s | %-10s | %-12s | %-10s | %-10s\n",
+				"Operation", "Avg (ms)", "Min (ms)", "Max (ms)", "Success", "MemAlloc(B)", "Wire (B)", "Count")
 			fmt.Println(strings.Repeat("-", 100))
 		}
 
 		memAllocKB := float64(stat.MemAllocAvg) / 1024.0
-		fmt.Printf("%-15s | %10.4f | %10.4f | %10.4f | %9.1f%% | %12.2f KB | %5d\n",
+		fmt.Printf("%-15s | %10.4f | %10.4f | %10.4f | %9.1f%% | %12.2f KB | %10d | %5d\n",
 			stat.Operation,
 			stat.AvgTimeMs,
 			stat.MinTimeMs,
 			stat.MaxTimeMs,
 			stat.SuccessRate,
 			memAllocKB,
+			stat.BytesAvg,
 			stat.Count)
 	}
 
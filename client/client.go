@@ -0,0 +1,191 @@
+// Package client defines what every protocol client offers and keeps the
+// registry of protocols the REPL and the benchmark can talk to. Protocol
+// packages register themselves from init, so importing one is enough to
+// make it available by name.
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+)
+
+type Client interface {
+	Login(ctx context.Context, studentId int) error
+	Logout(ctx context.Context, token string) error
+
+	Echo(ctx context.Context, message string) (EchoResult, error)
+	Sum(ctx context.Context, nums []int) (SumResult, error)
+	Timestamp(ctx context.Context) (ServerTime, error)
+	Status(ctx context.Context, detailed bool) (ServerStatus, error)
+	History(ctx context.Context, limit int) ([]HistoryEntry, error)
+
+	// Ping asks the server for its time on the saved session without
+	// returning anything, to keep the connection warm for heartbeats.
+	Ping(ctx context.Context) error
+
+	Target() (string, int)
+}
+
+// Options are the settings shared by every protocol's constructor, which
+// protocol clients embed. A zero Port means the protocol's default port.
+type Options struct {
+	Host      string
+	Port      int
+	Transport tcp.Transport
+
+	// Endpoint, when set to unix:///path, reaches the server over a Unix
+	// socket instead of Host and Port.
+	Endpoint string
+
+	// Logger receives wire dumps at debug level.
+	Logger *slog.Logger
+}
+
+// Target returns the host and port handed to the transport.
+func (o Options) Target() (string, int) {
+	if o.Endpoint != "" {
+		return o.Endpoint, 0
+	}
+	return o.Host, o.Port
+}
+
+// Log returns Logger, or slog.Default() when it is nil.
+func (o Options) Log() *slog.Logger {
+	if o.Logger == nil {
+		return slog.Default()
+	}
+	return o.Logger
+}
+
+// Transporter returns Transport, or tcp.Default when it is nil.
+func (o Options) Transporter() tcp.Transport {
+	if o.Transport == nil {
+		return tcp.Default
+	}
+	return o.Transport
+}
+
+type Protocol struct {
+	Name        string
+	Title       string
+	DefaultPort int
+	New         func(Options) Client
+}
+
+var (
+	mu        sync.RWMutex
+	protocols []Protocol
+)
+
+// Register makes a protocol available by name. It panics if the name is
+// taken, as two packages claiming one protocol is a programming error.
+func Register(p Protocol) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := find(p.Name); ok {
+		panic("client: protocol registered twice: " + p.Name)
+	}
+	protocols = append(protocols, p)
+	slices.SortFunc(protocols, func(a, b Protocol) int {
+		return a.DefaultPort - b.DefaultPort
+	})
+}
+
+func find(name string) (Protocol, bool) {
+	for _, p := range protocols {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Protocol{}, false
+}
+
+func Lookup(name string) (Protocol, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return find(name)
+}
+
+// Protocols returns the registered protocols ordered by default port.
+func Protocols() []Protocol {
+	mu.RLock()
+	defer mu.RUnlock()
+	return slices.Clone(protocols)
+}
+
+// Names lists the registered protocol names, ordered like Protocols.
+func Names() []string {
+	var names []string
+	for _, p := range Protocols() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// New builds a client for the named protocol.
+func New(name string, opts Options) (Client, error) {
+	p, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q (have %s)", name, strings.Join(Names(), ", "))
+	}
+	if opts.Port == 0 {
+		opts.Port = p.DefaultPort
+	}
+	return p.New(opts), nil
+}
+
+// Do runs op with arguments as typed in the REPL and returns its typed
+// result: EchoResult, SumResult, ServerTime, ServerStatus or []HistoryEntry.
+func Do(ctx context.Context, c Client, op string, args []string) (any, error) {
+	switch op {
+	case "echo":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%w: echo requires a message", ErrInvalidParams)
+		}
+		return c.Echo(ctx, strings.Join(args, " "))
+
+	case "sum", "soma":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%w: sum requires a comma-separated list, e.g. 1,2,3", ErrInvalidParams)
+		}
+		var nums []int
+		for _, p := range strings.Split(args[0], ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid number %q", ErrInvalidParams, p)
+			}
+			nums = append(nums, n)
+		}
+		return c.Sum(ctx, nums)
+
+	case "timestamp":
+		return c.Timestamp(ctx)
+
+	case "status":
+		return c.Status(ctx, true)
+
+	case "history", "historico":
+		limit := 10
+		if len(args) >= 1 {
+			if n, err := strconv.Atoi(args[0]); err == nil && n > 0 {
+				limit = n
+			}
+		}
+		return c.History(ctx, limit)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOperation, op)
+	}
+}
@@ -0,0 +1,16 @@
+package client
+
+import "github.com/erikbayerlein/mult-protocol-clients/internal/errs"
+
+// Errors returned by every protocol client. Failures reported by the server
+// are *ServerError values, which also match ErrServer and, when their code or
+// message is recognised, one of the other sentinels.
+var (
+	ErrNotAuthenticated = errs.ErrNotAuthenticated
+	ErrTokenExpired     = errs.ErrTokenExpired
+	ErrInvalidParams    = errs.ErrInvalidParams
+	ErrUnknownOperation = errs.ErrUnknownOperation
+	ErrServer           = errs.ErrServer
+)
+
+type ServerError = errs.ServerError
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type EchoResult struct {
+	Original   string
+	Echo       string
+	Length     int
+	MD5        string
+	ServerTime string
+}
+
+type SumResult struct {
+	Numbers []float64
+	Sum     float64
+	Mean    float64
+	Max     float64
+	Min     float64
+	Count   int
+}
+
+type ServerTime struct {
+	Formatted string
+	Unix      float64
+	Timezone  string
+	Weekday   string
+	Extra     map[string]any
+}
+
+type ServerStatus struct {
+	Status     string
+	Operations int
+	Uptime     string
+
+	// Detailed fields, present when the status was asked for in detail.
+	ActiveSessions int
+	Details        map[string]any
+}
+
+type HistoryEntry struct {
+	ID        string
+	Operation string
+	Time      string
+	Success   bool
+	Params    any
+	Result    any
+}
+
+// The Decode functions build results from an operation's "resultado" map.
+// String and protobuf servers send every value as text while the JSON server
+// sends typed values, so each field accepts either.
+
+func DecodeEcho(m map[string]any) EchoResult {
+	return EchoResult{
+		Original:   str(m["mensagem_original"]),
+		Echo:       str(m["mensagem_eco"]),
+		Length:     int(num(m["tamanho_mensagem"])),
+		MD5:        str(m["hash_md5"]),
+		ServerTime: str(m["timestamp_servidor"]),
+	}
+}
+
+func DecodeSum(m map[string]any) SumResult {
+	r := SumResult{
+		Sum:   num(m["soma"]),
+		Mean:  num(m["media"]),
+		Max:   num(m["maximo"]),
+		Min:   num(m["minimo"]),
+		Count: int(num(m["quantidade"])),
+	}
+	for _, v := range list(m["numeros_processados"]) {
+		r.Numbers = append(r.Numbers, num(v))
+	}
+	return r
+}
+
+func DecodeTime(m map[string]any) ServerTime {
+	t := ServerTime{
+		Formatted: str(m["timestamp_formatado"]),
+		Unix:      num(m["timestamp_unix"]),
+		Timezone:  str(m["timezone"]),
+		Weekday:   str(m["dia_semana"]),
+	}
+	if extra, ok := structured(m["informacoes_adicionais"]).(map[string]any); ok {
+		t.Extra = extra
+	}
+	return t
+}
+
+func DecodeStatus(m map[string]any) ServerStatus {
+	s := ServerStatus{
+		Status:         str(m["status"]),
+		Operations:     int(num(m["operacoes_processadas"])),
+		Uptime:         str(m["tempo_ativo"]),
+		ActiveSessions: int(num(m["sessoes_ativas"])),
+	}
+	for k, v := range m {
+		switch k {
+		case "status", "operacoes_processadas", "tempo_ativo", "sessoes_ativas":
+			continue
+		}
+		if s.Details == nil {
+			s.Details = make(map[string]any)
+		}
+		s.Details[k] = structured(v)
+	}
+	return s
+}
+
+func DecodeHistory(m map[string]any) []HistoryEntry {
+	var entries []HistoryEntry
+	for _, v := range list(m["operacoes"]) {
+		op, ok := structured(v).(map[string]any)
+		if !ok {
+			continue
+		}
+		entries = append(entries, HistoryEntry{
+			ID:        str(op["id"]),
+			Operation: str(op["operacao"]),
+			Time:      str(op["timestamp"]),
+			Success:   boolean(op["sucesso"]),
+			Params:    structured(op["parametros"]),
+			Result:    structured(op["resultado"]),
+		})
+	}
+	return entries
+}
+
+func str(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func num(v any) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f
+	}
+	return 0
+}
+
+func boolean(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(strings.ToLower(strings.TrimSpace(v)))
+		return b
+	}
+	return false
+}
+
+// list accepts a JSON array, an array encoded as text, or a comma-separated
+// string.
+func list(v any) []any {
+	switch v := structured(v).(type) {
+	case []any:
+		return v
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil
+		}
+		var out []any
+		for _, part := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(part))
+		}
+		return out
+	}
+	return nil
+}
+
+// structured decodes text that holds an object or array, written either as
+// JSON or as a Python literal, and returns anything else unchanged.
+func structured(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	s = strings.TrimSpace(s)
+	if s == "" || (s[0] != '[' && s[0] != '{') {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal([]byte(s), &out); err == nil {
+		return out
+	}
+	if err := json.Unmarshal([]byte(pythonToJSON(s)), &out); err == nil {
+		return out
+	}
+	return v
+}
+
+// pythonToJSON rewrites a Python literal's single-quoted strings and
+// True/False/None into their JSON spelling.
+func pythonToJSON(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '"':
+			j := i + 1
+			var lit strings.Builder
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				lit.WriteByte(s[j])
+			}
+			quoted, _ := json.Marshal(lit.String())
+			b.Write(quoted)
+			i = j
+		case strings.HasPrefix(s[i:], "True"):
+			b.WriteString("true")
+			i += 3
+		case strings.HasPrefix(s[i:], "False"):
+			b.WriteString("false")
+			i += 4
+		case strings.HasPrefix(s[i:], "None"):
+			b.WriteString("null")
+			i += 3
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
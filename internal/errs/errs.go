@@ -0,0 +1,91 @@
+// Package errs holds the error taxonomy shared by the protocol clients. It is
+// re-exported by package client; it lives here so that internal packages
+// such as auth can return the same errors without an import cycle.
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNotAuthenticated = errors.New("not authenticated")
+	ErrTokenExpired     = errors.New("session token invalid or expired")
+	ErrInvalidParams    = errors.New("invalid parameters")
+	ErrUnknownOperation = errors.New("unknown operation")
+	ErrServer           = errors.New("server error")
+)
+
+// ServerError is a failure reported by the server, whatever the protocol
+// that carried it. It matches ErrServer, and also the sentinel its code or
+// message points to, so errors.Is(err, ErrTokenExpired) works for all three
+// protocols.
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = "request failed"
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("server error %s: %s", e.Code, msg)
+	}
+	return "server error: " + msg
+}
+
+func (e *ServerError) Is(target error) bool {
+	return target == ErrServer || (target != nil && target == e.kind())
+}
+
+// keywords maps text fragments to the sentinel they point to.
+type keywords struct {
+	fragments []string
+	kind      error
+}
+
+// codeKinds is checked in order like messageKinds. An operation code only
+// counts when it says the operation is unknown; a generic ERRO_OPERACAO
+// falls through to the message.
+var codeKinds = []keywords{
+	{[]string{"TOKEN_AUSENTE", "TOKEN_NAO_FORNECIDO", "SEM_TOKEN", "MISSING_TOKEN", "TOKEN_MISSING", "NO_TOKEN"}, ErrNotAuthenticated},
+	{[]string{"TOKEN"}, ErrTokenExpired},
+	{[]string{"AUTENTIC", "AUTORIZ", "AUTH", "SESS"}, ErrNotAuthenticated},
+	{[]string{"PARAM"}, ErrInvalidParams},
+	{[]string{"OPERACAO_DESCONHECIDA", "OPERACAO_INVALIDA", "OPERACAO_NAO_SUPORTADA", "UNKNOWN_OP"}, ErrUnknownOperation},
+}
+
+// messageKinds is checked in order, so specific phrases come before the
+// words they contain: a missing token is not an expired one, and "operação
+// inválida" is an unknown operation rather than a bad parameter.
+var messageKinds = []keywords{
+	{[]string{"token não fornecido", "token nao fornecido", "token ausente", "missing token", "token required"}, ErrNotAuthenticated},
+	{[]string{"token"}, ErrTokenExpired},
+	{[]string{"autoriz", "autentic", "login", "sessão", "sessao"}, ErrNotAuthenticated},
+	{[]string{"parâmetro", "parametro", "parameter"}, ErrInvalidParams},
+	{[]string{"operação", "operacao", "operation"}, ErrUnknownOperation},
+	{[]string{"inválid", "invalid"}, ErrInvalidParams},
+}
+
+// kind picks the sentinel for the error's code, falling back to keywords in
+// its message when the code is missing or not recognised.
+func (e *ServerError) kind() error {
+	if k := match(strings.ToUpper(e.Code), codeKinds); k != nil {
+		return k
+	}
+	return match(strings.ToLower(e.Message), messageKinds)
+}
+
+func match(s string, kinds []keywords) error {
+	for _, k := range kinds {
+		for _, f := range k.fragments {
+			if strings.Contains(s, f) {
+				return k.kind
+			}
+		}
+	}
+	return nil
+}
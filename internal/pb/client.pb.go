@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.9
+// 	protoc-gen-go v1.36.10
 // 	protoc        v6.32.1
 // source: client.proto
 
@@ -224,8 +224,12 @@ func (x *Operacao) GetTimestamp() string {
 }
 
 type Resposta struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Operacao      *OperacaoResponse      `protobuf:"bytes,1,opt,name=operacao,proto3" json:"operacao,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Resultado:
+	//
+	//	*Resposta_Operacao
+	//	*Resposta_Erro
+	Resultado     isResposta_Resultado `protobuf_oneof:"resultado"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -260,13 +264,107 @@ func (*Resposta) Descriptor() ([]byte, []int) {
 	return file_client_proto_rawDescGZIP(), []int{3}
 }
 
+func (x *Resposta) GetResultado() isResposta_Resultado {
+	if x != nil {
+		return x.Resultado
+	}
+	return nil
+}
+
 func (x *Resposta) GetOperacao() *OperacaoResponse {
 	if x != nil {
-		return x.Operacao
+		if x, ok := x.Resultado.(*Resposta_Operacao); ok {
+			return x.Operacao
+		}
 	}
 	return nil
 }
 
+func (x *Resposta) GetErro() *RespostaErro {
+	if x != nil {
+		if x, ok := x.Resultado.(*Resposta_Erro); ok {
+			return x.Erro
+		}
+	}
+	return nil
+}
+
+type isResposta_Resultado interface {
+	isResposta_Resultado()
+}
+
+type Resposta_Operacao struct {
+	Operacao *OperacaoResponse `protobuf:"bytes,1,opt,name=operacao,proto3,oneof"`
+}
+
+type Resposta_Erro struct {
+	Erro *RespostaErro `protobuf:"bytes,2,opt,name=erro,proto3,oneof"`
+}
+
+func (*Resposta_Operacao) isResposta_Resultado() {}
+
+func (*Resposta_Erro) isResposta_Resultado() {}
+
+type RespostaErro struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Codigo        string                 `protobuf:"bytes,1,opt,name=codigo,proto3" json:"codigo,omitempty"`
+	Mensagem      string                 `protobuf:"bytes,2,opt,name=mensagem,proto3" json:"mensagem,omitempty"`
+	Timestamp     string                 `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RespostaErro) Reset() {
+	*x = RespostaErro{}
+	mi := &file_client_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RespostaErro) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RespostaErro) ProtoMessage() {}
+
+func (x *RespostaErro) ProtoReflect() protoreflect.Message {
+	mi := &file_client_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RespostaErro.ProtoReflect.Descriptor instead.
+func (*RespostaErro) Descriptor() ([]byte, []int) {
+	return file_client_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RespostaErro) GetCodigo() string {
+	if x != nil {
+		return x.Codigo
+	}
+	return ""
+}
+
+func (x *RespostaErro) GetMensagem() string {
+	if x != nil {
+		return x.Mensagem
+	}
+	return ""
+}
+
+func (x *RespostaErro) GetTimestamp() string {
+	if x != nil {
+		return x.Timestamp
+	}
+	return ""
+}
+
 type OperacaoResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Sucesso       bool                   `protobuf:"varint,1,opt,name=sucesso,proto3" json:"sucesso,omitempty"`
@@ -278,7 +376,7 @@ type OperacaoResponse struct {
 
 func (x *OperacaoResponse) Reset() {
 	*x = OperacaoResponse{}
-	mi := &file_client_proto_msgTypes[4]
+	mi := &file_client_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -290,7 +388,7 @@ func (x *OperacaoResponse) String() string {
 func (*OperacaoResponse) ProtoMessage() {}
 
 func (x *OperacaoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_client_proto_msgTypes[4]
+	mi := &file_client_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -303,7 +401,7 @@ func (x *OperacaoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use OperacaoResponse.ProtoReflect.Descriptor instead.
 func (*OperacaoResponse) Descriptor() ([]byte, []int) {
-	return file_client_proto_rawDescGZIP(), []int{4}
+	return file_client_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *OperacaoResponse) GetSucesso() bool {
@@ -350,9 +448,15 @@ const file_client_proto_rawDesc = "" +
 	"\ttimestamp\x18\x04 \x01(\tR\ttimestamp\x1a=\n" +
 	"\x0fParametrosEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"=\n" +
-	"\bResposta\x121\n" +
-	"\boperacao\x18\x01 \x01(\v2\x15.api.OperacaoResponseR\boperacao\"\xcc\x01\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"u\n" +
+	"\bResposta\x123\n" +
+	"\boperacao\x18\x01 \x01(\v2\x15.api.OperacaoResponseH\x00R\boperacao\x12'\n" +
+	"\x04erro\x18\x02 \x01(\v2\x11.api.RespostaErroH\x00R\x04erroB\v\n" +
+	"\tresultado\"`\n" +
+	"\fRespostaErro\x12\x16\n" +
+	"\x06codigo\x18\x01 \x01(\tR\x06codigo\x12\x1a\n" +
+	"\bmensagem\x18\x02 \x01(\tR\bmensagem\x12\x1c\n" +
+	"\ttimestamp\x18\x03 \x01(\tR\ttimestamp\"\xcc\x01\n" +
 	"\x10OperacaoResponse\x12\x18\n" +
 	"\asucesso\x18\x01 \x01(\bR\asucesso\x12B\n" +
 	"\tresultado\x18\x02 \x03(\v2$.api.OperacaoResponse.ResultadoEntryR\tresultado\x12\x1c\n" +
@@ -373,27 +477,29 @@ func file_client_proto_rawDescGZIP() []byte {
 	return file_client_proto_rawDescData
 }
 
-var file_client_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_client_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_client_proto_goTypes = []any{
 	(*Requisicao)(nil),       // 0: api.Requisicao
 	(*Auth)(nil),             // 1: api.Auth
 	(*Operacao)(nil),         // 2: api.Operacao
 	(*Resposta)(nil),         // 3: api.Resposta
-	(*OperacaoResponse)(nil), // 4: api.OperacaoResponse
-	nil,                      // 5: api.Operacao.ParametrosEntry
-	nil,                      // 6: api.OperacaoResponse.ResultadoEntry
+	(*RespostaErro)(nil),     // 4: api.RespostaErro
+	(*OperacaoResponse)(nil), // 5: api.OperacaoResponse
+	nil,                      // 6: api.Operacao.ParametrosEntry
+	nil,                      // 7: api.OperacaoResponse.ResultadoEntry
 }
 var file_client_proto_depIdxs = []int32{
 	1, // 0: api.Requisicao.auth:type_name -> api.Auth
 	2, // 1: api.Requisicao.operacao:type_name -> api.Operacao
-	5, // 2: api.Operacao.parametros:type_name -> api.Operacao.ParametrosEntry
-	4, // 3: api.Resposta.operacao:type_name -> api.OperacaoResponse
-	6, // 4: api.OperacaoResponse.resultado:type_name -> api.OperacaoResponse.ResultadoEntry
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	6, // 2: api.Operacao.parametros:type_name -> api.Operacao.ParametrosEntry
+	5, // 3: api.Resposta.operacao:type_name -> api.OperacaoResponse
+	4, // 4: api.Resposta.erro:type_name -> api.RespostaErro
+	7, // 5: api.OperacaoResponse.resultado:type_name -> api.OperacaoResponse.ResultadoEntry
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_client_proto_init() }
@@ -405,13 +511,17 @@ func file_client_proto_init() {
 		(*Requisicao_Auth)(nil),
 		(*Requisicao_Operacao)(nil),
 	}
+	file_client_proto_msgTypes[3].OneofWrappers = []any{
+		(*Resposta_Operacao)(nil),
+		(*Resposta_Erro)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_client_proto_rawDesc), len(file_client_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
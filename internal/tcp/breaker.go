@@ -0,0 +1,134 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without touching the network while an
+// endpoint's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStatus describes an endpoint's circuit breaker. RetryAt is when an
+// open circuit lets its next trial request through.
+type BreakerStatus struct {
+	State    BreakerState
+	Failures int
+	RetryAt  time.Time
+}
+
+// breaker opens after cfg.BreakerThreshold consecutive failed requests and
+// fails every request for cfg.BreakerCooldown. It then turns half-open and
+// lets a single trial through, whose outcome closes or reopens it.
+type breaker struct {
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	retryAt  time.Time
+	trial    bool
+}
+
+func (b *breaker) allow(address string, cfg Config) error {
+	if cfg.BreakerThreshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if wait := time.Until(b.retryAt); wait > 0 {
+			return fmt.Errorf("%w: %s failed %d times in a row, retry in %s",
+				ErrCircuitOpen, address, b.failures, wait.Round(100*time.Millisecond))
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			return fmt.Errorf("%w: %s is being probed", ErrCircuitOpen, address)
+		}
+	default:
+		return nil
+	}
+	b.trial = true
+	return nil
+}
+
+// done records the outcome of a request that allow let through and returns
+// the states before and after it. Cancelled and rate-limited requests say
+// nothing about the server and are ignored.
+func (b *breaker) done(cfg Config, err error) (from, to BreakerState) {
+	if cfg.BreakerThreshold <= 0 {
+		return BreakerClosed, BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	from = b.state
+	wasTrial := b.trial
+	b.trial = false
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrRateLimited) {
+		return from, b.state
+	}
+
+	if err == nil {
+		b.state = BreakerClosed
+		b.failures = 0
+		return from, b.state
+	}
+	b.failures++
+	if wasTrial || b.failures >= cfg.BreakerThreshold {
+		b.state = BreakerOpen
+		b.retryAt = time.Now().Add(cfg.BreakerCooldown)
+	}
+	return from, b.state
+}
+
+func (p *Pool) breakerFor(address string) *breaker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.breakers == nil {
+		p.breakers = make(map[string]*breaker)
+	}
+	b, ok := p.breakers[address]
+	if !ok {
+		b = &breaker{}
+		p.breakers[address] = b
+	}
+	return b
+}
+
+func (p *Pool) Breaker(host string, port int) BreakerStatus {
+	b := p.breakerFor(EndpointAddress(host, port))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state == BreakerOpen {
+		status.RetryAt = b.retryAt
+	}
+	return status
+}
@@ -0,0 +1,110 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// With compression enabled the top two bits of the length prefix are flags:
+// bit 31 marks a compressed body and bit 30 selects flate over gzip, leaving
+// 30 bits of length. Bodies shorter than MinCompressSize go out unflagged, so
+// the two ends only need to agree to use the scheme, not on every frame.
+//
+// The scheme is negotiated per connection: the client reads flagged replies
+// but sends plain frames until the server has sent a flagged frame of its
+// own. A server that knows nothing of the flags never sets them, as no
+// reply comes near 1 GiB, so it only ever sees plain length prefixes.
+const (
+	flagCompressed = 1 << 31
+	flagFlate      = 1 << 30
+	frameLenMask   = flagFlate - 1
+)
+
+const (
+	CompressionGzip  = "gzip"
+	CompressionFlate = "flate"
+)
+
+func validCompression(codec string) error {
+	switch codec {
+	case "", CompressionGzip, CompressionFlate:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression %q", codec)
+}
+
+// encodeFrame returns the length prefix and body to send for payload.
+// Nothing is compressed until the peer has shown it understands the flags.
+func encodeFrame(payload []byte, cfg Config, negotiated bool) (uint32, []byte, error) {
+	if err := validCompression(cfg.Compression); err != nil {
+		return 0, nil, err
+	}
+	if cfg.Compression == "" || !negotiated || len(payload) < cfg.MinCompressSize {
+		return uint32(len(payload)), payload, nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if cfg.Compression == CompressionFlate {
+		w, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, nil, err
+	}
+	if buf.Len() >= len(payload) {
+		return uint32(len(payload)), payload, nil
+	}
+
+	hdr := uint32(buf.Len()) | flagCompressed
+	if cfg.Compression == CompressionFlate {
+		hdr |= flagFlate
+	}
+	return hdr, buf.Bytes(), nil
+}
+
+// frameLength splits a received length prefix into the body length and
+// whether the body is compressed. Without compression configured the prefix
+// is a plain 32-bit length, as before.
+func frameLength(hdr uint32, cfg Config) (n uint32, compressed, useFlate bool) {
+	if cfg.Compression == "" || hdr&flagCompressed == 0 {
+		return hdr, false, false
+	}
+	return hdr & frameLenMask, true, hdr&flagFlate != 0
+}
+
+// decompress inflates body, refusing to produce more than limit bytes so a
+// small frame cannot expand into an unbounded allocation.
+func decompress(body []byte, useFlate bool, limit int) ([]byte, error) {
+	var r io.ReadCloser
+	if useFlate {
+		r = flate.NewReader(bytes.NewReader(body))
+	} else {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	}
+	defer r.Close()
+
+	src := io.Reader(r)
+	if limit > 0 {
+		src = io.LimitReader(r, int64(limit)+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(out) > limit {
+		return nil, &FrameTooLargeError{Size: int64(len(out)), Limit: limit}
+	}
+	return out, nil
+}
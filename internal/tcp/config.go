@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	ReadTimeout  time.Duration
+
+	MaxMessageSize int
+	MaxFrameSize   int
+
+	// Compression ("gzip" or "flate") enables flagged compressed frame
+	// bodies on the protobuf transport. Requests are only compressed once
+	// the server has sent a compressed frame itself; see compress.go.
+	Compression     string
+	MinCompressSize int
+
+	TLS *TLSConfig
+
+	// FallbackHosts are tried, on the same port, when no address of the
+	// endpoint's own host connects. FallbackDelay staggers the attempts.
+	FallbackHosts []string
+	FallbackDelay time.Duration
+
+	// Proxy is a socks5://, socks5h:// or http:// (CONNECT) URL. Empty means
+	// ALL_PROXY/HTTPS_PROXY from the environment; "direct" disables proxying.
+	Proxy string
+
+	MaxRetries  int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	// RateLimit throttles every request to the endpoint and OpRateLimits
+	// those of single operations, keyed by Operation name. Requests wait for
+	// a token unless RateLimitFailFast is set.
+	RateLimit         RateLimit
+	OpRateLimits      map[string]RateLimit
+	RateLimitFailFast bool
+
+	// BreakerThreshold consecutive failed requests open the endpoint's
+	// circuit, failing calls at once with ErrCircuitOpen for
+	// BreakerCooldown. Zero disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// KeepAlive is the TCP keepalive idle time and probe interval; a
+	// negative value disables keepalive. IdleTimeout replaces connections
+	// left unused for longer. Heartbeat, when set, is run every
+	// HeartbeatInterval of idleness to keep the session warm.
+	KeepAlive         time.Duration
+	IdleTimeout       time.Duration
+	HeartbeatInterval time.Duration
+	Heartbeat         func(ctx context.Context) error
+}
+
+var (
+	configMu        sync.RWMutex
+	defaultConfig   = DefaultConfig()
+	endpointConfigs = make(map[string]Config)
+)
+
+func DefaultConfig() Config {
+	return Config{
+		DialTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  10 * time.Second,
+
+		MaxMessageSize: 64 * 1024,
+		MaxFrameSize:   4 * 1024 * 1024,
+
+		MinCompressSize: 256,
+
+		FallbackDelay: 300 * time.Millisecond,
+
+		MaxRetries:  3,
+		BackoffBase: 100 * time.Millisecond,
+		BackoffMax:  2 * time.Second,
+
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+
+		KeepAlive:   30 * time.Second,
+		IdleTimeout: 5 * time.Minute,
+	}
+}
+
+// SetDefaultConfig replaces the configuration used by every endpoint that
+// has not been given its own through Configure.
+func SetDefaultConfig(cfg Config) {
+	configMu.Lock()
+	defaultConfig = cfg
+	configMu.Unlock()
+}
+
+// Configure overrides the configuration for host:port. An open connection
+// to that endpoint is closed so the next request dials with the new settings.
+func Configure(host string, port int, cfg Config) {
+	address := EndpointAddress(host, port)
+
+	configMu.Lock()
+	endpointConfigs[address] = cfg
+	configMu.Unlock()
+
+	_ = CloseEndpoint(host, port)
+}
+
+func configFor(address string) Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if cfg, ok := endpointConfigs[address]; ok {
+		return cfg
+	}
+	return defaultConfig
+}
+
+// deadline returns the earlier of now+timeout and the context deadline. A
+// zero timeout leaves only the context deadline in force.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var d time.Time
+	if timeout > 0 {
+		d = time.Now().Add(timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (d.IsZero() || ctxDeadline.Before(d)) {
+		d = ctxDeadline
+	}
+	return d
+}
@@ -0,0 +1,199 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// candidate is one address to try for an endpoint. target is the configured
+// host:port, kept for proxies and TLS verification; addr is what is dialled.
+type candidate struct {
+	target string
+	addr   string
+	proxy  *url.URL
+}
+
+// DialEndpoint connects to address, or to one of cfg.FallbackHosts on the
+// same port. Every host is resolved and its IPv6 and IPv4 addresses are
+// raced happy-eyeballs style: a new attempt starts each cfg.FallbackDelay,
+// or as soon as the previous one fails, and the first to connect wins.
+func DialEndpoint(ctx context.Context, address string, cfg Config) (net.Conn, error) {
+	if strings.HasPrefix(address, UnixScheme) {
+		return dialUnix(ctx, address, cfg)
+	}
+
+	cands, err := candidates(ctx, address, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	d := net.Dialer{Timeout: cfg.DialTimeout, KeepAliveConfig: keepAliveConfig(cfg)}
+	conn, won, err := race(ctx, cands, cfg.FallbackDelay, func(ctx context.Context, c candidate) (net.Conn, error) {
+		if c.proxy != nil {
+			conn, err := dialProxy(ctx, &d, c.proxy, c.target, cfg)
+			if err != nil {
+				return nil, err
+			}
+			return &tunnelConn{Conn: conn, target: c.target}, nil
+		}
+		return d.DialContext(ctx, "tcp", c.addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLS == nil {
+		return conn, nil
+	}
+
+	tlsConn, err := handshakeTLS(ctx, conn, won.target, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// candidates lists the addresses to try for address and its fallbacks, in
+// order. Hosts reached through a proxy are left for the proxy to resolve.
+func candidates(ctx context.Context, address string, cfg Config) ([]candidate, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var cands []candidate
+	var errs []error
+	for _, h := range append([]string{host}, cfg.FallbackHosts...) {
+		target := net.JoinHostPort(h, port)
+		proxy, err := proxyFor(target, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			cands = append(cands, candidate{target: target, addr: target, proxy: proxy})
+			continue
+		}
+
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, h)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, ip := range interleave(ips) {
+			cands = append(cands, candidate{target: target, addr: net.JoinHostPort(ip.String(), port)})
+		}
+	}
+	if len(cands) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cands, nil
+}
+
+// interleave alternates IPv6 and IPv4 addresses, IPv6 first, keeping the
+// resolver's order within each family.
+func interleave(ips []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+type dialResult struct {
+	conn net.Conn
+	cand candidate
+	err  error
+}
+
+// race dials cands with staggered starts and returns the first connection.
+// A zero delay tries them one after another. Losing connections are closed.
+func race(ctx context.Context, cands []candidate, delay time.Duration, dialOne func(context.Context, candidate) (net.Conn, error)) (net.Conn, candidate, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	next, pending := 0, 0
+	start := func() {
+		c := cands[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialOne(ctx, c)
+			results <- dialResult{conn: conn, cand: c, err: err}
+		}()
+	}
+
+	var timer <-chan time.Time
+	startNext := func() {
+		if next == len(cands) {
+			timer = nil
+			return
+		}
+		start()
+		if delay > 0 {
+			timer = time.After(delay)
+		}
+	}
+
+	startNext()
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer:
+			startNext()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if lost := <-results; lost.conn != nil {
+							_ = lost.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.cand, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", r.cand.addr, r.err))
+			startNext()
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, candidate{}, ctxErr
+	}
+	return nil, candidate{}, errors.Join(errs...)
+}
+
+// tunnelConn reports the proxied target rather than the proxy itself as its
+// remote address.
+type tunnelConn struct {
+	net.Conn
+	target string
+}
+
+func (t *tunnelConn) RemoteAddr() net.Addr {
+	return endpointAddr(t.target)
+}
+
+type endpointAddr string
+
+func (a endpointAddr) Network() string { return "tcp" }
+func (a endpointAddr) String() string  { return string(a) }
@@ -0,0 +1,112 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var fimTerminator = []byte("|FIM")
+
+type FrameTooLargeError struct {
+	Size  int64
+	Limit int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds the %d byte limit", e.Size, e.Limit)
+}
+
+// readFrame reads one line-protocol message from r. A message ends at a
+// newline or, for the string protocol, at the first |FIM whose '|' is not
+// escaped with a backslash, so a server that does not send a trailing newline
+// is still framed correctly. Blank lines left over between messages are
+// skipped. If the peer closes the connection mid-message the bytes read so
+// far are returned with io.EOF.
+func readFrame(r *bufio.Reader, limit int) ([]byte, error) {
+	var (
+		buf     []byte
+		escaped bool
+		pipe    = -1 // index of the last unescaped '|'
+	)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(buf) > 0 {
+				return buf, io.EOF
+			}
+			return nil, err
+		}
+
+		if len(buf) == 0 && (b == '\n' || b == '\r') {
+			continue
+		}
+		if b == '\n' {
+			return bytes.TrimSuffix(buf, []byte("\r")), nil
+		}
+
+		buf = append(buf, b)
+		if limit > 0 && len(buf) > limit {
+			return nil, &FrameTooLargeError{Size: int64(len(buf)), Limit: limit}
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case b == '\\':
+			escaped = true
+		case b == '|':
+			pipe = len(buf) - 1
+		case b == 'M' && pipe == len(buf)-len(fimTerminator) &&
+			bytes.HasSuffix(buf, fimTerminator) && !isJSONFrame(buf):
+			return buf, nil
+		}
+	}
+}
+
+// isJSONFrame reports whether the frame holds a JSON document, in which case
+// only a newline ends it: "|FIM" may legitimately appear inside a string.
+func isJSONFrame(buf []byte) bool {
+	trimmed := bytes.TrimLeft(buf, " \t")
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// limitedReader fails once more than limit bytes have been read since the last
+// reset, so a runaway JSON document cannot grow the decoder without bound.
+type limitedReader struct {
+	r     io.Reader
+	n     int64
+	limit int
+}
+
+func (l *limitedReader) reset(limit int) {
+	l.n = 0
+	l.limit = limit
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit > 0 && l.n > int64(l.limit) {
+		return n, &FrameTooLargeError{Size: l.n, Limit: l.limit}
+	}
+	return n, err
+}
+
+const bodyChunkSize = 64 * 1024
+
+// readBody reads an n byte frame body in chunks, growing the buffer only as
+// data actually arrives rather than trusting the header up front.
+func readBody(r io.Reader, n int) ([]byte, error) {
+	body := make([]byte, 0, min(n, bodyChunkSize))
+	for len(body) < n {
+		chunk := min(n-len(body), bodyChunkSize)
+		body = slices.Grow(body, chunk)[:len(body)+chunk]
+		if _, err := io.ReadFull(r, body[len(body)-chunk:]); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,136 @@
+package tcp
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Event describes one step in the life of a request or connection; Time is
+// when the step ended.
+//
+// Size and Payload are the application message as sent or received, before
+// framing and compression; Payload is only valid during the callback.
+// Duration is the time the step took: the dial, the write, the wait for and
+// read of the reply, the whole failed request for OnError, and how long the
+// connection was open for OnClose.
+type Event struct {
+	// Context is the context of the request, or Background for OnClose.
+	Context context.Context
+
+	Time      time.Time
+	Endpoint  string
+	Protocol  string
+	Operation string
+	Peer      string
+	Size      int
+	Payload   []byte
+	Duration  time.Duration
+	Err       error
+}
+
+// Hooks are callbacks run synchronously by a Pool. OnDial fires for every
+// dial attempt, with Err set when it failed. Callbacks must not block.
+type Hooks struct {
+	OnDial    func(Event)
+	OnSend    func(Event)
+	OnReceive func(Event)
+	OnError   func(Event)
+	OnClose   func(Event)
+}
+
+type hookKind int
+
+const (
+	hookDial hookKind = iota
+	hookSend
+	hookReceive
+	hookError
+	hookClose
+)
+
+func (h *Hooks) callback(kind hookKind) func(Event) {
+	switch kind {
+	case hookDial:
+		return h.OnDial
+	case hookSend:
+		return h.OnSend
+	case hookReceive:
+		return h.OnReceive
+	case hookError:
+		return h.OnError
+	case hookClose:
+		return h.OnClose
+	}
+	return nil
+}
+
+// AddHooks registers h on the pool and returns a func that removes it again.
+func (p *Pool) AddHooks(h Hooks) (remove func()) {
+	entry := &h
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var hooks []*Hooks
+	if cur := p.hooks.Load(); cur != nil {
+		hooks = slices.Clone(*cur)
+	}
+	hooks = append(hooks, entry)
+	p.hooks.Store(&hooks)
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		cur := p.hooks.Load()
+		if cur == nil {
+			return
+		}
+		hooks := slices.DeleteFunc(slices.Clone(*cur), func(e *Hooks) bool { return e == entry })
+		if len(hooks) == 0 {
+			p.hooks.Store(nil)
+			return
+		}
+		p.hooks.Store(&hooks)
+	}
+}
+
+func AddHooks(h Hooks) (remove func()) {
+	return Default.AddHooks(h)
+}
+
+// fire builds the event for key and ctx, lets fill complete it, and runs the
+// matching callbacks. fill is skipped when nothing is registered.
+func (p *Pool) fire(ctx context.Context, kind hookKind, key endpointKey, fill func(*Event)) {
+	hooks := p.hooks.Load()
+	if hooks == nil {
+		return
+	}
+
+	ev := Event{
+		Context:   ctx,
+		Time:      time.Now(),
+		Endpoint:  key.address,
+		Protocol:  key.framing.String(),
+		Operation: operationFrom(ctx).Name,
+	}
+	if fill != nil {
+		fill(&ev)
+	}
+	for _, h := range *hooks {
+		if fn := h.callback(kind); fn != nil {
+			fn(ev)
+		}
+	}
+}
+
+// transferred reports a message written to or read from the connection.
+func transferred[T ~string | ~[]byte](ctx context.Context, c *Connection, kind hookKind, payload T, start time.Time) {
+	c.pool.fire(ctx, kind, c.key, func(ev *Event) {
+		ev.Peer = c.peerAddr()
+		ev.Size = len(payload)
+		ev.Payload = []byte(payload)
+		ev.Duration = time.Since(start)
+	})
+}
@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// probeAfter is how long a connection must sit unused before the next
+// request first checks whether the peer has quietly closed it; probeWait
+// bounds that check.
+const (
+	probeAfter = time.Second
+	probeWait  = time.Millisecond
+)
+
+func (c *Connection) touch() {
+	c.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (c *Connection) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastUsed.Load()))
+}
+
+// stale reports whether the connection should be replaced before use: it has
+// been idle longer than cfg.IdleTimeout, or a non-blocking peek shows the
+// peer has already closed or reset it. Callers hold c.mu.
+func (c *Connection) stale(cfg Config) bool {
+	idle := c.idleFor()
+	if cfg.IdleTimeout > 0 && idle > cfg.IdleTimeout {
+		return true
+	}
+	if idle < probeAfter {
+		return false
+	}
+	// A "|FIM" frame may leave its line ending behind. Anything else
+	// buffered between requests is a reply nobody asked for, so the stream
+	// is out of step and the connection is replaced.
+	if n := c.r.Buffered(); n > 0 {
+		b, _ := c.r.Peek(n)
+		if len(bytes.Trim(b, "\r\n")) > 0 {
+			return true
+		}
+		_, _ = c.r.Discard(n)
+	}
+
+	// A deadline already in the past fails before looking at the socket, so
+	// give the peek a moment to see a pending EOF or reset.
+	_ = c.conn.SetReadDeadline(time.Now().Add(probeWait))
+	_, err := c.r.Peek(1)
+	_ = c.conn.SetReadDeadline(time.Time{})
+	return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// startHeartbeat runs cfg.Heartbeat whenever the connection has been idle
+// for a full cfg.HeartbeatInterval, until the connection is dropped. The
+// heartbeat goes through the normal request path, so a dead socket is
+// redialled by the heartbeat rather than by the user's next command.
+func (c *Connection) startHeartbeat(cfg Config) {
+	if cfg.HeartbeatInterval <= 0 || cfg.Heartbeat == nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.hbStop = stop
+	go func() {
+		t := time.NewTicker(cfg.HeartbeatInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			if c.idleFor() < cfg.HeartbeatInterval {
+				continue
+			}
+
+			timeout := cfg.ReadTimeout + cfg.WriteTimeout
+			if timeout <= 0 {
+				timeout = cfg.HeartbeatInterval
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_ = cfg.Heartbeat(ctx)
+			cancel()
+		}
+	}()
+}
+
+func (c *Connection) stopHeartbeat() {
+	if c.hbStop != nil {
+		close(c.hbStop)
+		c.hbStop = nil
+	}
+}
+
+func keepAliveConfig(cfg Config) net.KeepAliveConfig {
+	if cfg.KeepAlive < 0 {
+		return net.KeepAliveConfig{Enable: false}
+	}
+	return net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     cfg.KeepAlive,
+		Interval: cfg.KeepAlive,
+		Count:    -1,
+	}
+}
@@ -0,0 +1,327 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the dial and request
+// duration histograms.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func (f framing) String() string {
+	switch f {
+	case lineFraming:
+		return "string"
+	case jsonFraming:
+		return "json"
+	case lengthFraming:
+		return "proto"
+	}
+	return "unknown"
+}
+
+// Histogram is a snapshot of a duration histogram. Counts[i] is the number
+// of observations no larger than Bounds[i] seconds, cumulative as in
+// Prometheus; Count and Sum cover every observation.
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return time.Duration(h.Sum / float64(h.Count) * float64(time.Second))
+}
+
+// Quantile estimates the q-quantile by interpolating inside the bucket it
+// falls in. Observations above the last bound are reported as that bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	lower, below := 0.0, uint64(0)
+	for i, upper := range h.Bounds {
+		if float64(h.Counts[i]) >= rank {
+			inBucket := h.Counts[i] - below
+			frac := 1.0
+			if inBucket > 0 {
+				frac = (rank - float64(below)) / float64(inBucket)
+			}
+			return time.Duration((lower + (upper-lower)*frac) * float64(time.Second))
+		}
+		lower, below = upper, h.Counts[i]
+	}
+	return time.Duration(h.Bounds[len(h.Bounds)-1] * float64(time.Second))
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	if i, _ := slices.BinarySearch(latencyBuckets, s); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += s
+}
+
+func (h *histogram) snapshot() Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := Histogram{
+		Bounds: latencyBuckets,
+		Counts: make([]uint64, len(latencyBuckets)),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+	var cum uint64
+	for i := range out.Counts {
+		if h.counts != nil {
+			cum += h.counts[i]
+		}
+		out.Counts[i] = cum
+	}
+	return out
+}
+
+// endpointStats accumulates the metrics of one endpoint and framing.
+type endpointStats struct {
+	traffic  trafficCounter
+	requests atomic.Uint64
+	dial     histogram
+	latency  histogram
+
+	mu     sync.Mutex
+	errors map[string]uint64
+}
+
+func (s *endpointStats) record(d time.Duration, err error) {
+	s.requests.Add(1)
+	s.latency.observe(d)
+	if err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errors == nil {
+		s.errors = make(map[string]uint64)
+	}
+	s.errors[errorClass(err)]++
+}
+
+// errorClass buckets a transport error for the errors metric.
+func errorClass(err error) string {
+	var (
+		netErr    net.Error
+		dnsErr    *net.DNSError
+		tooLarge  *FrameTooLargeError
+		syntaxErr *json.SyntaxError
+		verifyErr *tls.CertificateVerificationError
+		alertErr  tls.AlertError
+		recordErr tls.RecordHeaderError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &verifyErr), errors.As(err, &alertErr), errors.As(err, &recordErr):
+		return "tls"
+	case isBroken(err):
+		return "broken"
+	case errors.As(err, &tooLarge):
+		return "frame_too_large"
+	case errors.As(err, &syntaxErr):
+		return "malformed"
+	}
+	return "other"
+}
+
+// EndpointMetrics are the totals for one endpoint and protocol since the
+// pool first used it.
+type EndpointMetrics struct {
+	Endpoint      string
+	Protocol      string
+	Requests      uint64
+	Errors        map[string]uint64
+	BytesSent     uint64
+	BytesReceived uint64
+	DialTime      Histogram
+	Latency       Histogram
+}
+
+func (p *Pool) statsFor(address string, f framing) *endpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stats == nil {
+		p.stats = make(map[endpointKey]*endpointStats)
+	}
+	key := endpointKey{address: address, framing: f}
+	s, ok := p.stats[key]
+	if !ok {
+		s = &endpointStats{}
+		p.stats[key] = s
+	}
+	return s
+}
+
+// Metrics returns a snapshot of every endpoint the pool has used, ordered by
+// endpoint and protocol.
+func (p *Pool) Metrics() []EndpointMetrics {
+	p.mu.Lock()
+	keys := make([]endpointKey, 0, len(p.stats))
+	stats := make([]*endpointStats, 0, len(p.stats))
+	for key, s := range p.stats {
+		keys = append(keys, key)
+		stats = append(stats, s)
+	}
+	p.mu.Unlock()
+
+	out := make([]EndpointMetrics, len(keys))
+	for i, s := range stats {
+		s.mu.Lock()
+		errs := maps.Clone(s.errors)
+		s.mu.Unlock()
+
+		out[i] = EndpointMetrics{
+			Endpoint:      keys[i].address,
+			Protocol:      keys[i].framing.String(),
+			Requests:      s.requests.Load(),
+			Errors:        errs,
+			BytesSent:     s.traffic.sent.Load(),
+			BytesReceived: s.traffic.received.Load(),
+			DialTime:      s.dial.snapshot(),
+			Latency:       s.latency.snapshot(),
+		}
+	}
+	slices.SortFunc(out, func(a, b EndpointMetrics) int {
+		if c := strings.Compare(a.Endpoint, b.Endpoint); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Protocol, b.Protocol)
+	})
+	return out
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the pool's metrics in the Prometheus text format.
+func (p *Pool) WritePrometheus(w io.Writer) error {
+	metrics := p.Metrics()
+	var b strings.Builder
+
+	labels := func(m EndpointMetrics, extra string) string {
+		l := fmt.Sprintf(`endpoint="%s",protocol="%s"`, labelEscaper.Replace(m.Endpoint), m.Protocol)
+		if extra != "" {
+			l += "," + extra
+		}
+		return "{" + l + "}"
+	}
+	counter := func(name, help string, value func(EndpointMetrics) uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, m := range metrics {
+			fmt.Fprintf(&b, "%s%s %d\n", name, labels(m, ""), value(m))
+		}
+	}
+	histogram := func(name, help string, value func(EndpointMetrics) Histogram) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, m := range metrics {
+			h := value(m)
+			for i, bound := range h.Bounds {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, labels(m, fmt.Sprintf(`le="%g"`, bound)), h.Counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, labels(m, `le="+Inf"`), h.Count)
+			fmt.Fprintf(&b, "%s_sum%s %g\n", name, labels(m, ""), h.Sum)
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, labels(m, ""), h.Count)
+		}
+	}
+
+	counter("tcp_client_requests_total", "Requests made, counted once however often they were retried.",
+		func(m EndpointMetrics) uint64 { return m.Requests })
+	counter("tcp_client_bytes_sent_total", "Bytes written, framing included.",
+		func(m EndpointMetrics) uint64 { return m.BytesSent })
+	counter("tcp_client_bytes_received_total", "Bytes read, framing included.",
+		func(m EndpointMetrics) uint64 { return m.BytesReceived })
+
+	fmt.Fprintf(&b, "# HELP tcp_client_errors_total Failed requests by error class.\n# TYPE tcp_client_errors_total counter\n")
+	for _, m := range metrics {
+		for _, class := range slices.Sorted(maps.Keys(m.Errors)) {
+			fmt.Fprintf(&b, "tcp_client_errors_total%s %d\n", labels(m, fmt.Sprintf(`class="%s"`, class)), m.Errors[class])
+		}
+	}
+
+	histogram("tcp_client_dial_duration_seconds", "Time to establish a connection, TLS and proxy included.",
+		func(m EndpointMetrics) Histogram { return m.DialTime })
+	histogram("tcp_client_request_duration_seconds", "Round-trip time of a request, as seen by the caller.",
+		func(m EndpointMetrics) Histogram { return m.Latency })
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MetricsHandler serves WritePrometheus over HTTP.
+func (p *Pool) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = p.WritePrometheus(w)
+	})
+}
+
+// ServeMetrics exposes p's metrics at http://addr/metrics until the returned
+// server is shut down. Listening errors are reported before it returns.
+func ServeMetrics(addr string, p *Pool) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p.MetricsHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	return srv, nil
+}
@@ -0,0 +1,259 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyFor returns the proxy to reach address through, or nil to dial it
+// directly. Config.Proxy wins; "direct" disables proxying, and an empty value
+// falls back to ALL_PROXY or HTTPS_PROXY, honouring NO_PROXY.
+func proxyFor(address string, cfg Config) (*url.URL, error) {
+	raw := cfg.Proxy
+	if raw == "direct" {
+		return nil, nil
+	}
+	if raw == "" {
+		host, _, _ := net.SplitHostPort(address)
+		if noProxy(host) {
+			return nil, nil
+		}
+		raw = firstEnv("ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy")
+		if raw == "" {
+			return nil, nil
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		port := "1080"
+		if u.Scheme == "http" {
+			port = "8080"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func noProxy(host string) bool {
+	for _, entry := range strings.Split(firstEnv("NO_PROXY", "no_proxy"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || entry == host {
+			return true
+		}
+		if strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialProxy connects to the proxy and asks it for a tunnel to address.
+func dialProxy(ctx context.Context, d *net.Dialer, proxy *url.URL, address string, cfg Config) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+
+	_ = conn.SetDeadline(deadline(ctx, cfg.DialTimeout))
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if proxy.Scheme == "http" {
+		err = httpConnect(conn, proxy, address)
+	} else {
+		err = socks5Connect(conn, proxy, address)
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func httpConnect(conn net.Conn, proxy *url.URL, address string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u := proxy.User; u != nil {
+		pass, _ := u.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT refused: %s", resp.Status)
+	}
+	return nil
+}
+
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0x00
+	socksUserPass     = 0x02
+	socksNoAcceptable = 0xff
+	socksConnect      = 0x01
+	socksIPv4         = 0x01
+	socksDomain       = 0x03
+	socksIPv6         = 0x04
+)
+
+func socks5Connect(conn net.Conn, proxy *url.URL, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{socksNoAuth}
+	if proxy.User != nil {
+		methods = append(methods, socksUserPass)
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if err := socks5Auth(conn, proxy.User); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		return errors.New("SOCKS5 proxy accepted none of our auth methods")
+	default:
+		return fmt.Errorf("unsupported SOCKS5 auth method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, socksIPv4), ip4...)
+		} else {
+			req = append(append(req, socksIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long for SOCKS5: %s", host)
+		}
+		req = append(append(req, socksDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[1] != 0 {
+		return fmt.Errorf("SOCKS5 connect failed with code %d", hdr[1])
+	}
+
+	var skip int
+	switch hdr[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", hdr[3])
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	if user == nil {
+		return errors.New("SOCKS5 proxy requires a username and password")
+	}
+	name := user.Username()
+	pass, _ := user.Password()
+	if len(name) > 255 || len(pass) > 255 {
+		return errors.New("SOCKS5 credentials too long")
+	}
+
+	msg := []byte{1, byte(len(name))}
+	msg = append(msg, name...)
+	msg = append(msg, byte(len(pass)))
+	msg = append(msg, pass...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("SOCKS5 authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ErrRateLimited is returned, in fail-fast mode, by requests that would have
+// to wait for the rate limiter or for a backoff the server asked for.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit is a token bucket: Rate requests per second on average, with
+// bursts of up to Burst. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	burst := float64(max(b.limit.Burst, 1))
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// delay is how long until a token is free, without taking it.
+func (b *bucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+type limitKey struct {
+	address string
+	op      string
+}
+
+// penalty is the backoff requested by an endpoint that replied it was
+// receiving too many requests.
+type penalty struct {
+	until   time.Time
+	strikes int
+}
+
+// limiter holds the token buckets and server backoffs of a Pool.
+type limiter struct {
+	mu        sync.Mutex
+	buckets   map[limitKey]*bucket
+	penalties map[string]*penalty
+}
+
+// bucketFor returns the bucket for key, starting it full, or afresh when its
+// limit was reconfigured. Callers hold l.mu.
+func (l *limiter) bucketFor(key limitKey, limit RateLimit, now time.Time) *bucket {
+	if l.buckets == nil {
+		l.buckets = make(map[limitKey]*bucket)
+	}
+	b, ok := l.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{limit: limit, tokens: float64(max(limit.Burst, 1)), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// acquire takes a token from the endpoint's bucket and from the operation's,
+// if they are limited, and waits until both are available and any server
+// backoff has passed. With cfg.RateLimitFailFast it fails instead of waiting.
+func (l *limiter) acquire(ctx context.Context, address, op string, cfg Config) error {
+	now := time.Now()
+	l.mu.Lock()
+
+	var buckets []*bucket
+	if cfg.RateLimit.Rate > 0 {
+		buckets = append(buckets, l.bucketFor(limitKey{address: address}, cfg.RateLimit, now))
+	}
+	if limit := cfg.OpRateLimits[op]; limit.Rate > 0 && op != "" {
+		buckets = append(buckets, l.bucketFor(limitKey{address: address, op: op}, limit, now))
+	}
+
+	var wait time.Duration
+	if pen, ok := l.penalties[address]; ok {
+		wait = pen.until.Sub(now)
+	}
+	for _, b := range buckets {
+		wait = max(wait, b.delay())
+	}
+	if wait > 0 && cfg.RateLimitFailFast {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: %s, retry in %s", ErrRateLimited, address, wait.Round(time.Millisecond))
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleep(ctx, wait); err != nil {
+		l.mu.Lock()
+		for _, b := range buckets {
+			b.tokens++
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// throttled backs address off after it asked for fewer requests, for longer
+// with each consecutive request.
+func (l *limiter) throttled(address string, cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.penalties == nil {
+		l.penalties = make(map[string]*penalty)
+	}
+	pen, ok := l.penalties[address]
+	if !ok {
+		pen = &penalty{}
+		l.penalties[address] = pen
+	}
+	pen.until = time.Now().Add(max(backoff(cfg, pen.strikes), cfg.BackoffBase))
+	pen.strikes++
+}
+
+func (l *limiter) recovered(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.penalties, address)
+}
+
+var throttleRe = regexp.MustCompile(`(?i)too many requests|rate.?limit|muitas (requisi|solicita)|limite de (requisi|taxa)`)
+
+// isThrottled reports whether reply is an error reply telling the client to
+// slow down. Successful replies are never matched, so an echoed message that
+// happens to say "too many requests" does not count.
+func isThrottled(f framing, reply []byte) bool {
+	if !throttleRe.Match(reply) {
+		return false
+	}
+	switch f {
+	case lineFraming:
+		return bytes.HasPrefix(bytes.TrimSpace(reply), []byte("ERROR"))
+	case jsonFraming:
+		return jsonFailureRe.Match(reply)
+	case lengthFraming:
+		return !protoSucceeded(reply)
+	}
+	return false
+}
+
+var jsonFailureRe = regexp.MustCompile(`"sucesso"\s*:\s*false`)
+
+// protoSucceeded reports whether a Resposta frame carries an OperacaoResponse
+// with sucesso set, reading the wire format directly so the transport stays
+// independent of the generated types.
+func protoSucceeded(b []byte) bool {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+		if num != 1 || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return false
+			}
+			b = b[n:]
+			continue
+		}
+
+		inner, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+		for len(inner) > 0 {
+			num, typ, n := protowire.ConsumeTag(inner)
+			if n < 0 {
+				return false
+			}
+			inner = inner[n:]
+			if num == 1 && typ == protowire.VarintType {
+				v, _ := protowire.ConsumeVarint(inner)
+				return v != 0
+			}
+			if n = protowire.ConsumeFieldValue(num, typ, inner); n < 0 {
+				return false
+			}
+			inner = inner[n:]
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const redacted = "[REDACTED]"
+
+// Record is one request/response exchange as written to a JSONL trace.
+// Protobuf frames are stored base64-encoded, text protocols verbatim.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Protocol  string    `json:"protocol"`
+	Operation string    `json:"operation,omitempty"`
+	Endpoint  string    `json:"endpoint"`
+	Direction string    `json:"direction"`
+	Encoding  string    `json:"encoding,omitempty"`
+	Request   string    `json:"request"`
+	Response  string    `json:"response,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var (
+	textTokenRe = regexp.MustCompile(`(token=)[^|\s]*`)
+	jsonTokenRe = regexp.MustCompile(`("token"\s*:\s*")[^"]*(")`)
+)
+
+// Redact masks session tokens in a string or JSON message so it can be
+// logged.
+func Redact(message string) string {
+	out := textTokenRe.ReplaceAllString(message, "${1}"+redacted)
+	return jsonTokenRe.ReplaceAllString(out, "${1}"+redacted+"${2}")
+}
+
+// Recorder is a Transport that forwards to Next and appends every exchange
+// to W as a JSON line. Session tokens are redacted unless KeepTokens is set.
+type Recorder struct {
+	Next       Transport
+	W          io.Writer
+	KeepTokens bool
+
+	mu      sync.Mutex
+	secrets map[string]struct{}
+}
+
+func NewRecorder(next Transport, w io.Writer) *Recorder {
+	return &Recorder{Next: next, W: w}
+}
+
+// AddSecret registers a value, such as a token obtained before recording
+// started, to be masked wherever it appears in the trace.
+func (r *Recorder) AddSecret(secret string) {
+	if secret == "" || secret == redacted {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.secrets == nil {
+		r.secrets = make(map[string]struct{})
+	}
+	r.secrets[secret] = struct{}{}
+}
+
+func (r *Recorder) Request(ctx context.Context, message string, host string, port int) (string, error) {
+	start := time.Now()
+	resp, err := r.Next.Request(ctx, message, host, port)
+	r.record(ctx, "string", host, port, start, []byte(message), []byte(resp), err)
+	return resp, err
+}
+
+func (r *Recorder) RequestJSON(ctx context.Context, message string, host string, port int) (json.RawMessage, error) {
+	start := time.Now()
+	resp, err := r.Next.RequestJSON(ctx, message, host, port)
+	r.record(ctx, "json", host, port, start, []byte(message), resp, err)
+	return resp, err
+}
+
+func (r *Recorder) RequestBytes(ctx context.Context, payload []byte, host string, port int) ([]byte, error) {
+	start := time.Now()
+	resp, err := r.Next.RequestBytes(ctx, payload, host, port)
+	r.record(ctx, "proto", host, port, start, payload, resp, err)
+	return resp, err
+}
+
+func (r *Recorder) CloseEndpoint(host string, port int) error {
+	return r.Next.CloseEndpoint(host, port)
+}
+
+func (r *Recorder) record(ctx context.Context, protocol, host string, port int, start time.Time, req, resp []byte, err error) {
+	rec := Record{
+		Time:      start.UTC(),
+		Protocol:  protocol,
+		Operation: operationFrom(ctx).Name,
+		Endpoint:  EndpointAddress(host, port),
+		Direction: "outbound",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.KeepTokens {
+		req = r.redact(protocol, req)
+		resp = r.redact(protocol, resp)
+	}
+	if protocol == "proto" {
+		rec.Encoding = "base64"
+		rec.Request = base64.StdEncoding.EncodeToString(req)
+		rec.Response = base64.StdEncoding.EncodeToString(resp)
+	} else {
+		rec.Request = string(req)
+		rec.Response = string(resp)
+	}
+
+	line, jsonErr := json.Marshal(rec)
+	if jsonErr != nil {
+		return
+	}
+	_, _ = r.W.Write(append(line, '\n'))
+}
+
+// redact masks tokens in b. Tokens seen in text messages or in protobuf
+// "token" map entries are remembered, so a token returned by a login is also
+// masked when it is later sent inside a binary frame. Binary frames are
+// masked byte for byte to keep their length prefixes valid. Callers hold r.mu.
+func (r *Recorder) redact(protocol string, b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	if r.secrets == nil {
+		r.secrets = make(map[string]struct{})
+	}
+
+	if protocol == "proto" {
+		collectProtoTokens(b, r.secrets, 0)
+		out := bytes.Clone(b)
+		for secret := range r.secrets {
+			mask := bytes.Repeat([]byte("*"), len(secret))
+			out = bytes.ReplaceAll(out, []byte(secret), mask)
+		}
+		return out
+	}
+
+	for _, re := range []*regexp.Regexp{textTokenRe, jsonTokenRe} {
+		for _, m := range re.FindAllSubmatchIndex(b, -1) {
+			value := string(b[m[3]:m[1]])
+			if re == jsonTokenRe {
+				value = string(b[m[3]:m[4]])
+			}
+			if value != "" && value != redacted {
+				r.secrets[value] = struct{}{}
+			}
+		}
+	}
+	out := textTokenRe.ReplaceAll(b, []byte("${1}"+redacted))
+	out = jsonTokenRe.ReplaceAll(out, []byte("${1}"+redacted+"${2}"))
+	for secret := range r.secrets {
+		out = bytes.ReplaceAll(out, []byte(secret), []byte(redacted))
+	}
+	return out
+}
+
+// collectProtoTokens walks a protobuf message without its schema and records
+// the value of every map entry whose key is "token".
+func collectProtoTokens(b []byte, secrets map[string]struct{}, depth int) {
+	if depth > 8 {
+		return
+	}
+
+	var key, value []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return
+			}
+			b = b[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+
+		switch num {
+		case 1:
+			key = v
+		case 2:
+			value = v
+		}
+		collectProtoTokens(v, secrets, depth+1)
+	}
+
+	if string(key) == "token" && len(value) > 0 {
+		secrets[string(value)] = struct{}{}
+	}
+}
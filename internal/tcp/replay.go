@@ -0,0 +1,156 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+var ErrNotRecorded = errors.New("no recorded response")
+
+var normalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`((?:token|timestamp)=)[^|\s]*`), "${1}*"},
+	{regexp.MustCompile(`("(?:token|timestamp)"\s*:\s*")[^"]*(")`), "${1}*${2}"},
+}
+
+// Replay is a Transport that answers from a trace written by Recorder
+// instead of the network. A request is matched on protocol, operation and
+// its text with tokens and timestamps normalised; protobuf frames, which
+// embed timestamps, and unmatched text fall back to protocol and operation
+// alone. Responses recorded for the same key are served in order, and the
+// last one is repeated once they run out.
+type Replay struct {
+	mu      sync.Mutex
+	exact   map[string]*replayQueue
+	byOp    map[string]*replayQueue
+	records int
+}
+
+type replayQueue struct {
+	records []Record
+	next    int
+}
+
+func (q *replayQueue) pop() Record {
+	rec := q.records[q.next]
+	if q.next < len(q.records)-1 {
+		q.next++
+	}
+	return rec
+}
+
+func NewReplay(r io.Reader) (*Replay, error) {
+	rp := &Replay{
+		exact: make(map[string]*replayQueue),
+		byOp:  make(map[string]*replayQueue),
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+		rp.add(rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+func (rp *Replay) Len() int {
+	return rp.records
+}
+
+func (rp *Replay) add(rec Record) {
+	opKey := rec.Protocol + "|" + rec.Operation
+	push := func(m map[string]*replayQueue, key string) {
+		q, ok := m[key]
+		if !ok {
+			q = &replayQueue{}
+			m[key] = q
+		}
+		q.records = append(q.records, rec)
+	}
+
+	push(rp.byOp, opKey)
+	if rec.Protocol != "proto" {
+		push(rp.exact, opKey+"|"+normalize(rec.Request))
+	}
+	rp.records++
+}
+
+func normalize(s string) string {
+	for _, n := range normalizers {
+		s = n.re.ReplaceAllString(s, n.repl)
+	}
+	return s
+}
+
+func (rp *Replay) lookup(ctx context.Context, protocol, request string) (Record, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	op := operationFrom(ctx).Name
+	opKey := protocol + "|" + op
+	if protocol != "proto" {
+		if q, ok := rp.exact[opKey+"|"+normalize(request)]; ok {
+			return q.pop(), nil
+		}
+	}
+	if q, ok := rp.byOp[opKey]; ok {
+		return q.pop(), nil
+	}
+	return Record{}, fmt.Errorf("%w for %s operation %q", ErrNotRecorded, protocol, op)
+}
+
+func (rp *Replay) Request(ctx context.Context, message string, host string, port int) (string, error) {
+	rec, err := rp.lookup(ctx, "string", message)
+	if err != nil {
+		return "", err
+	}
+	if rec.Error != "" {
+		return "", errors.New(rec.Error)
+	}
+	return rec.Response, nil
+}
+
+func (rp *Replay) RequestJSON(ctx context.Context, message string, host string, port int) (json.RawMessage, error) {
+	rec, err := rp.lookup(ctx, "json", message)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Error != "" {
+		return nil, errors.New(rec.Error)
+	}
+	return json.RawMessage(rec.Response), nil
+}
+
+func (rp *Replay) RequestBytes(ctx context.Context, payload []byte, host string, port int) ([]byte, error) {
+	rec, err := rp.lookup(ctx, "proto", "")
+	if err != nil {
+		return nil, err
+	}
+	if rec.Error != "" {
+		return nil, errors.New(rec.Error)
+	}
+	return base64.StdEncoding.DecodeString(rec.Response)
+}
+
+func (rp *Replay) CloseEndpoint(host string, port int) error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+type Operation struct {
+	Name       string
+	Idempotent bool
+}
+
+type operationKey struct{}
+
+// WithOperation tags ctx with the operation about to be sent. Only
+// operations marked Idempotent are resent after the connection breaks.
+func WithOperation(ctx context.Context, op Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+func operationFrom(ctx context.Context) Operation {
+	op, _ := ctx.Value(operationKey{}).(Operation)
+	return op
+}
+
+// isBroken reports whether err means the peer went away, as opposed to a
+// timeout or a protocol error on a healthy socket.
+func isBroken(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// backoff returns a fully jittered exponential delay for the given retry.
+func backoff(cfg Config, attempt int) time.Duration {
+	d := cfg.BackoffBase << attempt
+	if d <= 0 || (cfg.BackoffMax > 0 && d > cfg.BackoffMax) {
+		d = cfg.BackoffMax
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d) + 1
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -2,111 +2,430 @@ package tcp
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+type framing int
+
+const (
+	lineFraming framing = iota
+	lengthFraming
+	jsonFraming
+)
+
+type endpointKey struct {
+	address string
+	framing framing
+}
+
 type Connection struct {
 	conn net.Conn
+	r    *bufio.Reader
 	mu   sync.Mutex
+
+	dec *json.Decoder
+	lr  *limitedReader
+
+	pool     *Pool
+	key      endpointKey
+	openedAt time.Time
+	lastUsed atomic.Int64
+	hbStop   chan struct{}
+	peer     atomic.Pointer[string]
+
+	// compresses is set once the peer has sent a compressed frame, which
+	// is what allows compressing requests to it; see compress.go.
+	compresses bool
 }
 
-var sharedConn *Connection
+// connectionFor returns the connection registered for address and framing,
+// creating an empty entry on first use. The caller dials it under c.mu.
+func (p *Pool) connectionFor(address string, f framing) *Connection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-func Request(message string, host string, port int) (string, error) {
-	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if p.conns == nil {
+		p.conns = make(map[endpointKey]*Connection)
+	}
+	key := endpointKey{address: address, framing: f}
+	c, ok := p.conns[key]
+	if !ok {
+		c = &Connection{pool: p, key: key}
+		p.conns[key] = c
+	}
+	return c
+}
 
-	if sharedConn == nil || sharedConn.conn == nil {
-		c, err := net.Dial("tcp", address)
-		if err != nil {
-			return "", fmt.Errorf("connection error: %v", err)
+// dial opens the connection if it is not already open, retrying with
+// backoff. Nothing has been sent yet, so this is safe for every operation.
+func (c *Connection) dial(ctx context.Context, address string, cfg Config, dialFn DialFunc, stats *endpointStats) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt <= cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if sleepErr := sleep(ctx, backoff(cfg, attempt-1)); sleepErr != nil {
+				return sleepErr
+			}
 		}
-		sharedConn = &Connection{conn: c}
+		start := time.Now()
+		conn, dialErr := dialFn(ctx, address, cfg)
+		c.pool.fire(ctx, hookDial, c.key, func(ev *Event) {
+			ev.Duration = time.Since(start)
+			ev.Err = dialErr
+			if dialErr == nil {
+				ev.Peer = conn.RemoteAddr().String()
+			}
+		})
+		if dialErr == nil {
+			stats.dial.observe(time.Since(start))
+			c.conn = &countingConn{Conn: conn, t: &stats.traffic}
+			c.r = bufio.NewReader(c.conn)
+			peer := conn.RemoteAddr().String()
+			c.peer.Store(&peer)
+			c.openedAt = time.Now()
+			c.touch()
+			c.startHeartbeat(cfg)
+			c.pool.logger().DebugContext(ctx, "connected",
+				"endpoint", address, "protocol", c.key.framing.String(), "peer", peer, "duration", time.Since(start))
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		c.pool.logger().WarnContext(ctx, "dial failed",
+			"endpoint", address, "protocol", c.key.framing.String(), "attempt", attempt+1, "err", dialErr)
+		err = dialErr
 	}
+	return fmt.Errorf("connection error: %w", err)
+}
 
-	sharedConn.mu.Lock()
-	defer sharedConn.mu.Unlock()
+// watch interrupts blocked I/O on the connection once ctx is done. The
+// returned func must be called when the exchange is over.
+func (c *Connection) watch(ctx context.Context) func() bool {
+	conn := c.conn
+	return context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+}
 
-	_, err := fmt.Fprintf(sharedConn.conn, "%s\n", message)
-	if err != nil {
-		return "", fmt.Errorf("send error: %v", err)
+func (c *Connection) drop() error {
+	if c.conn == nil {
+		return nil
 	}
+	c.stopHeartbeat()
+	err := c.conn.Close()
+	c.pool.fire(context.Background(), hookClose, c.key, func(ev *Event) {
+		ev.Peer = c.peerAddr()
+		ev.Duration = time.Since(c.openedAt)
+		ev.Err = err
+	})
+	c.conn = nil
+	c.r = nil
+	c.dec = nil
+	c.compresses = false
+	c.peer.Store(nil)
+	return err
+}
 
-	buff := make([]byte, 64*1024)
-	n, err := sharedConn.conn.Read(buff)
-	if err != nil && !strings.Contains(err.Error(), "EOF") {
-		return "", fmt.Errorf("read error: %v", err)
+// fail drops the connection after an error that may have left it mid-frame,
+// and reports the context error instead when the exchange was cancelled.
+func (c *Connection) fail(ctx context.Context, err error) error {
+	_ = c.drop()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
 	}
+	return err
+}
 
-	trimmedData := bytes.TrimRight(buff[:n], "\x00")
-	response := strings.TrimSpace(string(trimmedData))
+func (c *Connection) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return response, nil
+	return c.drop()
 }
 
-func RequestBytes(payload []byte, host string, port int) ([]byte, error) {
-	address := net.JoinHostPort(host, strconv.Itoa(port))
+// roundTrip runs exchange on the connection for address and framing and
+// returns the reply it read. When the peer has closed or reset the socket
+// the connection is redialled, and the exchange is repeated if the
+// operation in ctx is idempotent. Requests first pass the circuit breaker
+// and the rate limiter, and a reply asking the client to slow down backs the
+// endpoint off.
+func (p *Pool) roundTrip(ctx context.Context, address string, f framing, exchange func(c *Connection, cfg Config) ([]byte, error)) (reply []byte, err error) {
+	cfg := configFor(address)
+	op := operationFrom(ctx)
+	dialFn := p.Dial
+	if dialFn == nil {
+		dialFn = DialEndpoint
+	}
 
-	if sharedConn == nil || sharedConn.conn == nil {
-		c, err := net.Dial("tcp", address)
+	stats := p.statsFor(address, f)
+	start := time.Now()
+	defer func() {
+		stats.record(time.Since(start), err)
 		if err != nil {
-			return nil, fmt.Errorf("connection error: %v", err)
+			p.fire(ctx, hookError, endpointKey{address: address, framing: f}, func(ev *Event) {
+				ev.Duration = time.Since(start)
+				ev.Err = err
+			})
 		}
-		sharedConn = &Connection{conn: c}
+	}()
+
+	br := p.breakerFor(address)
+	if err := br.allow(address, cfg); err != nil {
+		return nil, err
 	}
+	defer func() {
+		from, to := br.done(cfg, err)
+		switch {
+		case to == BreakerOpen && from != BreakerOpen:
+			p.logger().WarnContext(ctx, "circuit opened", "endpoint", address, "cooldown", cfg.BreakerCooldown, "err", err)
+		case to == BreakerClosed && from != BreakerClosed:
+			p.logger().InfoContext(ctx, "circuit closed", "endpoint", address)
+		}
+	}()
 
-	sharedConn.mu.Lock()
-	defer sharedConn.mu.Unlock()
+	c := p.connectionFor(address, f)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	w := bufio.NewWriter(sharedConn.conn)
-	r := bufio.NewReader(sharedConn.conn)
+	for attempt := 0; ; attempt++ {
+		if err := p.limits.acquire(ctx, address, op.Name, cfg); err != nil {
+			return nil, err
+		}
+		if c.conn != nil && c.stale(cfg) {
+			p.logger().DebugContext(ctx, "reconnecting stale connection", "endpoint", address, "protocol", f.String())
+			_ = c.drop()
+		}
+		if err := c.dial(ctx, address, cfg, dialFn, stats); err != nil {
+			return nil, err
+		}
 
-	var hdr [4]byte
-	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
-	if _, err := w.Write(hdr[:]); err != nil {
-		return nil, fmt.Errorf("send header error: %v", err)
-	}
-	if _, err := w.Write(payload); err != nil {
-		return nil, fmt.Errorf("send payload error: %v", err)
+		stop := c.watch(ctx)
+		reply, err := exchange(c, cfg)
+		stop()
+		if err == nil {
+			c.touch()
+			if !isThrottled(f, reply) {
+				p.limits.recovered(address)
+				return reply, nil
+			}
+			p.limits.throttled(address, cfg)
+			p.logger().WarnContext(ctx, "server asked to slow down", "endpoint", address, "operation", op.Name)
+			if !op.Idempotent || attempt >= cfg.MaxRetries {
+				return reply, nil
+			}
+			continue
+		}
+
+		err = c.fail(ctx, err)
+		if !isBroken(err) || !op.Idempotent || attempt >= cfg.MaxRetries {
+			return nil, err
+		}
+		p.logger().InfoContext(ctx, "retrying on a new connection",
+			"endpoint", address, "operation", op.Name, "attempt", attempt+1, "err", err)
+		if sleepErr := sleep(ctx, backoff(cfg, attempt)); sleepErr != nil {
+			return nil, sleepErr
+		}
 	}
-	if err := w.Flush(); err != nil {
-		return nil, fmt.Errorf("flush error: %v", err)
+}
+
+func (p *Pool) Request(ctx context.Context, message string, host string, port int) (string, error) {
+	address := EndpointAddress(host, port)
+
+	reply, err := p.roundTrip(ctx, address, lineFraming, func(c *Connection, cfg Config) ([]byte, error) {
+		start := time.Now()
+		_ = c.conn.SetWriteDeadline(deadline(ctx, cfg.WriteTimeout))
+		if _, err := fmt.Fprintf(c.conn, "%s\n", message); err != nil {
+			return nil, fmt.Errorf("send error: %w", err)
+		}
+		transferred(ctx, c, hookSend, message, start)
+
+		start = time.Now()
+		_ = c.conn.SetReadDeadline(deadline(ctx, cfg.ReadTimeout))
+		frame, err := readFrame(c.r, cfg.MaxMessageSize)
+		if err != nil && (len(frame) == 0 || !errors.Is(err, io.EOF)) {
+			return nil, fmt.Errorf("read error: %w", err)
+		}
+		transferred(ctx, c, hookReceive, frame, start)
+		if err != nil {
+			_ = c.drop()
+		}
+		return frame, nil
+	})
+	if err != nil {
+		return "", err
 	}
 
-	if _, err := io.ReadFull(r, hdr[:]); err != nil {
-		if !strings.Contains(err.Error(), "EOF") {
-			return nil, fmt.Errorf("read header error: %v", err)
+	return strings.TrimSpace(string(reply)), nil
+}
+
+// RequestJSON sends message as one line and decodes exactly one JSON
+// value from the reply stream. The decoder lives as long as the connection,
+// so bytes that arrive after the value are kept for the next response.
+func (p *Pool) RequestJSON(ctx context.Context, message string, host string, port int) (json.RawMessage, error) {
+	address := EndpointAddress(host, port)
+
+	reply, err := p.roundTrip(ctx, address, jsonFraming, func(c *Connection, cfg Config) ([]byte, error) {
+		if c.dec == nil {
+			c.lr = &limitedReader{r: c.r}
+			c.dec = json.NewDecoder(c.lr)
+		}
+
+		start := time.Now()
+		_ = c.conn.SetWriteDeadline(deadline(ctx, cfg.WriteTimeout))
+		if _, err := fmt.Fprintf(c.conn, "%s\n", message); err != nil {
+			return nil, fmt.Errorf("send error: %w", err)
+		}
+		transferred(ctx, c, hookSend, message, start)
+
+		start = time.Now()
+		_ = c.conn.SetReadDeadline(deadline(ctx, cfg.ReadTimeout))
+		c.lr.reset(cfg.MaxMessageSize)
+		var response json.RawMessage
+		if err := c.dec.Decode(&response); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, fmt.Errorf("malformed JSON response: %w", err)
+			}
+			return nil, fmt.Errorf("read error: %w", err)
 		}
+		transferred(ctx, c, hookReceive, response, start)
+		return response, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	n := binary.BigEndian.Uint32(hdr[:])
-	if n == 0 {
-		return []byte{}, nil
+	return json.RawMessage(reply), nil
+}
+
+func (p *Pool) RequestBytes(ctx context.Context, payload []byte, host string, port int) ([]byte, error) {
+	address := EndpointAddress(host, port)
+
+	return p.roundTrip(ctx, address, lengthFraming, func(c *Connection, cfg Config) ([]byte, error) {
+		start := time.Now()
+		w := bufio.NewWriter(c.conn)
+
+		prefix, body, err := encodeFrame(payload, cfg, c.compresses)
+		if err != nil {
+			return nil, fmt.Errorf("encode frame error: %w", err)
+		}
+
+		_ = c.conn.SetWriteDeadline(deadline(ctx, cfg.WriteTimeout))
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], prefix)
+		if _, err := w.Write(hdr[:]); err != nil {
+			return nil, fmt.Errorf("send header error: %w", err)
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, fmt.Errorf("send payload error: %w", err)
+		}
+		if err := w.Flush(); err != nil {
+			return nil, fmt.Errorf("flush error: %w", err)
+		}
+		transferred(ctx, c, hookSend, payload, start)
+
+		start = time.Now()
+		_ = c.conn.SetReadDeadline(deadline(ctx, cfg.ReadTimeout))
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return nil, fmt.Errorf("read header error: %w", err)
+		}
+
+		n, compressed, useFlate := frameLength(binary.BigEndian.Uint32(hdr[:]), cfg)
+		if cfg.MaxFrameSize > 0 && int64(n) > int64(cfg.MaxFrameSize) {
+			return nil, &FrameTooLargeError{Size: int64(n), Limit: cfg.MaxFrameSize}
+		}
+
+		body, err = readBody(c.r, int(n))
+		if err != nil {
+			return nil, fmt.Errorf("read body error: %w", err)
+		}
+		if compressed {
+			if body, err = decompress(body, useFlate, cfg.MaxFrameSize); err != nil {
+				return nil, fmt.Errorf("decompress body error: %w", err)
+			}
+			c.compresses = true
+		}
+		transferred(ctx, c, hookReceive, body, start)
+		return body, nil
+	})
+}
+
+// Peer returns the address that the open connection to host:port actually
+// reached, or "" when nothing is connected.
+func (p *Pool) Peer(host string, port int) string {
+	address := EndpointAddress(host, port)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, c := range p.conns {
+		if key.address != address {
+			continue
+		}
+		if peer := c.peerAddr(); peer != "" {
+			return peer
+		}
+	}
+	return ""
+}
+
+func (c *Connection) peerAddr() string {
+	if peer := c.peer.Load(); peer != nil {
+		return *peer
 	}
+	return ""
+}
+
+// CloseEndpoint closes every connection registered for host:port, whatever
+// its framing, leaving connections to other servers untouched.
+func (p *Pool) CloseEndpoint(host string, port int) error {
+	address := EndpointAddress(host, port)
 
-	resp := make([]byte, n)
-	if _, err := io.ReadFull(r, resp); err != nil {
-		if !strings.Contains(err.Error(), "EOF") {
-			return nil, fmt.Errorf("read body error: %v", err)
+	p.mu.Lock()
+	var conns []*Connection
+	for key, c := range p.conns {
+		if key.address == address {
+			conns = append(conns, c)
 		}
-		return nil, err
 	}
+	p.mu.Unlock()
 
-	return resp, nil
+	var firstErr error
+	for _, c := range conns {
+		if err := c.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-func Close() error {
-	if sharedConn == nil || sharedConn.conn == nil {
-		return nil
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	conns := make([]*Connection, 0, len(p.conns))
+	for _, c := range p.conns {
+		conns = append(conns, c)
 	}
-	err := sharedConn.conn.Close()
-	sharedConn.conn = nil
-	return err
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, c := range conns {
+		if err := c.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Traffic is the number of bytes written to and read from an endpoint since
+// the pool first dialled it, framing and compression included.
+type Traffic struct {
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+type trafficCounter struct {
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+type countingConn struct {
+	net.Conn
+	t *trafficCounter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.t.received.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.t.sent.Add(uint64(n))
+	return n, err
+}
+
+func (p *Pool) Traffic(host string, port int) Traffic {
+	address := EndpointAddress(host, port)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var t Traffic
+	for key, s := range p.stats {
+		if key.address == address {
+			t.BytesSent += s.traffic.sent.Load()
+			t.BytesReceived += s.traffic.received.Load()
+		}
+	}
+	return t
+}
@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Transport carries one request to host:port and returns its reply, using
+// the framing of the calling protocol: a text line for the string client,
+// one JSON value for the JSON client and a length-prefixed frame for protobuf.
+type Transport interface {
+	Request(ctx context.Context, message string, host string, port int) (string, error)
+	RequestJSON(ctx context.Context, message string, host string, port int) (json.RawMessage, error)
+	RequestBytes(ctx context.Context, payload []byte, host string, port int) ([]byte, error)
+	CloseEndpoint(host string, port int) error
+}
+
+type DialFunc func(ctx context.Context, address string, cfg Config) (net.Conn, error)
+
+// Pool is the network Transport. It keeps one connection per endpoint and
+// framing. Dial replaces the default TCP/TLS dialer, which lets tests hand
+// out one end of a net.Pipe instead of a socket. Logger receives connection
+// events; nil means slog.Default(). The zero value is ready to use.
+type Pool struct {
+	Dial   DialFunc
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	conns    map[endpointKey]*Connection
+	stats    map[endpointKey]*endpointStats
+	breakers map[string]*breaker
+	hooks    atomic.Pointer[[]*Hooks]
+
+	limits limiter
+}
+
+var Default = &Pool{}
+
+func (p *Pool) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+func Request(message string, host string, port int) (string, error) {
+	return Default.Request(context.Background(), message, host, port)
+}
+
+func RequestContext(ctx context.Context, message string, host string, port int) (string, error) {
+	return Default.Request(ctx, message, host, port)
+}
+
+func RequestJSON(message string, host string, port int) (json.RawMessage, error) {
+	return Default.RequestJSON(context.Background(), message, host, port)
+}
+
+func RequestJSONContext(ctx context.Context, message string, host string, port int) (json.RawMessage, error) {
+	return Default.RequestJSON(ctx, message, host, port)
+}
+
+func RequestBytes(payload []byte, host string, port int) ([]byte, error) {
+	return Default.RequestBytes(context.Background(), payload, host, port)
+}
+
+func RequestBytesContext(ctx context.Context, payload []byte, host string, port int) ([]byte, error) {
+	return Default.RequestBytes(ctx, payload, host, port)
+}
+
+func CloseEndpoint(host string, port int) error {
+	return Default.CloseEndpoint(host, port)
+}
+
+func Close() error {
+	return Default.Close()
+}
@@ -0,0 +1,28 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// UnixScheme prefixes a host naming a Unix domain socket, as in
+// "unix:///run/server.sock". The port is ignored for such endpoints.
+const UnixScheme = "unix://"
+
+// EndpointAddress returns the address host and port are registered, dialled
+// and recorded under.
+func EndpointAddress(host string, port int) string {
+	if strings.HasPrefix(host, UnixScheme) {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// dialUnix connects to a unix:// address. Proxies, fallbacks and TLS only
+// apply to TCP endpoints.
+func dialUnix(ctx context.Context, address string, cfg Config) (net.Conn, error) {
+	d := net.Dialer{Timeout: cfg.DialTimeout}
+	return d.DialContext(ctx, "unix", strings.TrimPrefix(address, UnixScheme))
+}
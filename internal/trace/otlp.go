@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const scopeName = "github.com/erikbayerlein/mult-protocol-clients"
+
+// FileExporter writes spans as OTLP-JSON, one ExportTraceServiceRequest per
+// line, the layout of the OpenTelemetry collector's file exporter. Spans are
+// held back until the root of their trace ends so that each line holds a
+// whole trace.
+type FileExporter struct {
+	w       io.WriteCloser
+	service string
+
+	mu      sync.Mutex
+	pending map[string][]SpanData
+}
+
+func NewFileExporter(w io.WriteCloser, service string) *FileExporter {
+	return &FileExporter{w: w, service: service, pending: make(map[string][]SpanData)}
+}
+
+func (e *FileExporter) Export(s SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.pending[s.TraceID] = append(e.pending[s.TraceID], s)
+	if s.ParentID == "" {
+		e.flush(s.TraceID)
+	}
+}
+
+// Close writes the spans of traces whose root never ended and closes the
+// underlying writer.
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for id := range e.pending {
+		e.flush(id)
+	}
+	return e.w.Close()
+}
+
+// flush writes the spans collected for traceID. Callers hold e.mu.
+func (e *FileExporter) flush(traceID string) {
+	spans := e.pending[traceID]
+	delete(e.pending, traceID)
+
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = toOTLP(s)
+	}
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{
+			{Key: "service.name", Value: otlpValue{StringValue: &e.service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: out,
+		}},
+	}}}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	_, _ = e.w.Write(append(line, '\n'))
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue; int64s are strings in the protobuf JSON mapping.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	statusOK    = 1
+	statusError = 2
+)
+
+func toOTLP(s SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentID,
+		Name:              s.Name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusOK},
+	}
+	if s.ParentID == "" {
+		span.Kind = spanKindClient
+	}
+	if s.Err != nil {
+		span.Status = otlpStatus{Code: statusError, Message: s.Err.Error()}
+	}
+
+	for _, a := range s.Attrs {
+		var v otlpValue
+		switch val := a.Value.(type) {
+		case string:
+			v.StringValue = &val
+		case int64:
+			str := strconv.FormatInt(val, 10)
+			v.IntValue = &str
+		case bool:
+			v.BoolValue = &val
+		default:
+			continue
+		}
+		span.Attributes = append(span.Attributes, otlpAttr{Key: a.Key, Value: v})
+	}
+	return span
+}
@@ -0,0 +1,168 @@
+// Package trace records spans for client operations and exports them, in
+// OTLP-JSON, to a local file that trace viewers can load.
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: int64(value)}
+}
+
+// SpanData is a finished span as handed to an Exporter. ParentID is empty
+// for root spans.
+type SpanData struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    []Attr
+	Err      error
+}
+
+type Exporter interface {
+	Export(SpanData)
+	Close() error
+}
+
+type exporterBox struct{ e Exporter }
+
+var current atomic.Pointer[exporterBox]
+
+// SetExporter starts sending finished spans to e; nil turns tracing off.
+// The previous exporter, if any, is closed.
+func SetExporter(e Exporter) error {
+	var box *exporterBox
+	if e != nil {
+		box = &exporterBox{e: e}
+	}
+	if old := current.Swap(box); old != nil {
+		return old.e.Close()
+	}
+	return nil
+}
+
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Span is an operation in progress. A nil *Span, returned while tracing is
+// off, accepts every call and records nothing.
+type Span struct {
+	data SpanData
+
+	mu    sync.Mutex
+	ended bool
+}
+
+type spanKey struct{}
+
+func fromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func newID(n int) string {
+	b := make([]byte, 0, 16)
+	for len(b) < n {
+		v := rand.Uint64()
+		for i := 0; i < 8 && len(b) < n; i++ {
+			b = append(b, byte(v>>(8*i)))
+		}
+	}
+	return hex.EncodeToString(b)
+}
+
+// Start begins a span named name, as a child of the span in ctx if there
+// is one, and returns a context carrying it.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	s := &Span{data: SpanData{
+		SpanID: newID(8),
+		Name:   name,
+		Start:  time.Now(),
+		Attrs:  attrs,
+	}}
+	if parent := fromContext(ctx); parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentID = parent.data.SpanID
+	} else {
+		s.data.TraceID = newID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+}
+
+// End finishes the span, marking it failed when err is not nil, and exports
+// it. Only the first call has any effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.Err = err
+	data := s.data
+	s.mu.Unlock()
+
+	export(data)
+}
+
+// Record exports a span that has already happened, from start to end, as a
+// child of the span in ctx. It does nothing when ctx carries no span.
+func Record(ctx context.Context, name string, start, end time.Time, err error, attrs ...Attr) {
+	parent := fromContext(ctx)
+	if parent == nil {
+		return
+	}
+	export(SpanData{
+		TraceID:  parent.data.TraceID,
+		SpanID:   newID(8),
+		ParentID: parent.data.SpanID,
+		Name:     name,
+		Start:    start,
+		End:      end,
+		Attrs:    attrs,
+		Err:      err,
+	})
+}
+
+func export(data SpanData) {
+	if box := current.Load(); box != nil {
+		box.e.Export(data)
+	}
+}
@@ -0,0 +1,33 @@
+package trace
+
+import (
+	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+)
+
+// TransportHooks turns transport events into dial, write and wait spans
+// under the span of the request that caused them.
+func TransportHooks() tcp.Hooks {
+	span := func(name string) func(tcp.Event) {
+		return func(ev tcp.Event) {
+			attrs := []Attr{
+				String("endpoint", ev.Endpoint),
+				String("protocol", ev.Protocol),
+			}
+			if ev.Operation != "" {
+				attrs = append(attrs, String("operation", ev.Operation))
+			}
+			if ev.Peer != "" {
+				attrs = append(attrs, String("peer", ev.Peer))
+			}
+			if name != "dial" {
+				attrs = append(attrs, Int("bytes", ev.Size))
+			}
+			Record(ev.Context, name, ev.Time.Add(-ev.Duration), ev.Time, ev.Err, attrs...)
+		}
+	}
+	return tcp.Hooks{
+		OnDial:    span("dial"),
+		OnSend:    span("write"),
+		OnReceive: span("wait"),
+	}
+}
@@ -1,138 +1,236 @@
 package json
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
-	"strings"
 
+	"github.com/erikbayerlein/mult-protocol-clients/client"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/auth"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/trace"
 )
 
 type JsonClient struct {
-	Host string
-	Port int
+	client.Options
 }
 
-func (jc *JsonClient) Login(studentId int) error {
+func init() {
+	client.Register(client.Protocol{
+		Name:        "json",
+		Title:       "JSON",
+		DefaultPort: 8081,
+		New: func(o client.Options) client.Client {
+			return &JsonClient{Options: o}
+		},
+	})
+}
+
+func (jc *JsonClient) Login(ctx context.Context, studentId int) (err error) {
+	ctx, span := trace.Start(ctx, "json.Login", trace.String("protocol", "json"), trace.String("operation", "auth"))
+	defer func() { span.End(err) }()
+
 	authReq := Auth{
 		Type:      "autenticar",
 		StudentId: strconv.Itoa(studentId),
 	}
 
+	_, marshal := trace.Start(ctx, "marshal")
 	payload, err := json.Marshal(authReq)
+	marshal.End(err)
 	if err != nil {
 		return fmt.Errorf("marshal auth request: %w", err)
 	}
 
-	token, err := auth.Auth(string(payload), jc.Host, jc.Port)
+	req := string(payload)
+	jc.Log().DebugContext(ctx, "sending request", "request", tcp.Redact(req))
+
+	ctx = tcp.WithOperation(ctx, tcp.Operation{Name: "auth"})
+	host, port := jc.Target()
+	raw, err := jc.Transporter().RequestJSON(ctx, req, host, port)
 	if err != nil {
 		return err
 	}
 
-	if err := auth.SaveToken(auth.TokenRecord{StudentId: studentId, Token: token}); err != nil {
+	jc.Log().DebugContext(ctx, "received response", "response", tcp.Redact(string(raw)))
+
+	var resp AuthResponse
+	_, unmarshal := trace.Start(ctx, "unmarshal")
+	err = json.Unmarshal(raw, &resp)
+	unmarshal.End(err)
+	if err != nil {
+		return fmt.Errorf("decode auth response: %w", err)
+	}
+	if resp.Token == "" {
+		if resp.Error != "" {
+			return &client.ServerError{Code: resp.Details.Code, Message: resp.Error}
+		}
+		return fmt.Errorf("token not found in response")
+	}
+
+	if err := auth.SaveToken(auth.TokenRecord{StudentId: studentId, Token: resp.Token}); err != nil {
 		return fmt.Errorf("could not save token: %w", err)
 	}
 	return nil
 }
 
-func (jc *JsonClient) Logout(token string) error {
+func (jc *JsonClient) Logout(ctx context.Context, token string) (err error) {
+	ctx, span := trace.Start(ctx, "json.Logout", trace.String("protocol", "json"), trace.String("operation", "logout"))
+	defer func() { span.End(err) }()
+
 	logoutReq := Logout{
 		Type:  "logout",
 		Token: token,
 	}
 
+	_, marshal := trace.Start(ctx, "marshal")
 	payload, err := json.Marshal(logoutReq)
+	marshal.End(err)
 	if err != nil {
 		return fmt.Errorf("marshal logout request: %w", err)
 	}
 
 	req := string(payload)
-	fmt.Printf("Sending request: %s\n", req)
-	return auth.LogoutRemote(req, jc.Host, jc.Port)
-}
+	jc.Log().DebugContext(ctx, "sending request", "request", tcp.Redact(req))
 
-func (jc *JsonClient) Run(op string, args []string) error {
-	rec, err := auth.RequireLogin()
+	ctx = tcp.WithOperation(ctx, tcp.Operation{Name: "logout"})
+	host, port := jc.Target()
+	resp, err := jc.Transporter().RequestJSON(ctx, req, host, port)
+	jc.Transporter().CloseEndpoint(host, port)
 	if err != nil {
 		return err
 	}
-	token := rec.Token
+	jc.Log().DebugContext(ctx, "received response", "response", tcp.Redact(string(resp)))
 
-	var params any
-	switch op {
-	case "echo":
-		if len(args) < 1 {
-			return fmt.Errorf("echo requires a message")
-		}
-		params = EchoParams{Message: strings.Join(args, " ")}
+	var reply OperationResponse
+	if err := json.Unmarshal(resp, &reply); err != nil {
+		return fmt.Errorf("decode logout response: %w", err)
+	}
+	return reply.err()
+}
 
-	case "sum":
-		if len(args) < 1 {
-			return fmt.Errorf("sum requires a comma-separated list")
-		}
-		parts := strings.Split(args[0], ",")
-		nums := make([]int, 0, len(parts))
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p == "" {
-				continue
-			}
-			v, conv := strconv.Atoi(p)
-			if conv != nil {
-				return fmt.Errorf("invalid number %q", p)
-			}
-			nums = append(nums, v)
-		}
-		params = SumParams{Numeros: nums}
+func (jc *JsonClient) Echo(ctx context.Context, message string) (client.EchoResult, error) {
+	m, err := jc.operate(ctx, "echo", EchoParams{Message: message})
+	if err != nil {
+		return client.EchoResult{}, err
+	}
+	return client.DecodeEcho(m), nil
+}
 
-	case "timestamp":
-		params = TimestampParams{}
+func (jc *JsonClient) Sum(ctx context.Context, nums []int) (client.SumResult, error) {
+	m, err := jc.operate(ctx, "soma", SumParams{Numeros: nums})
+	if err != nil {
+		return client.SumResult{}, err
+	}
+	return client.DecodeSum(m), nil
+}
 
-	case "status":
-		params = StatusParams{Detalhado: true}
+func (jc *JsonClient) Timestamp(ctx context.Context) (client.ServerTime, error) {
+	m, err := jc.operate(ctx, "timestamp", TimestampParams{})
+	if err != nil {
+		return client.ServerTime{}, err
+	}
+	return client.DecodeTime(m), nil
+}
 
-	case "history":
-		limit := 10
-		if len(args) >= 1 {
-			if v, conv := strconv.Atoi(args[0]); conv == nil && v > 0 {
-				limit = v
-			}
-		}
-		params = HistoryParams{Limite: limit}
+func (jc *JsonClient) Status(ctx context.Context, detailed bool) (client.ServerStatus, error) {
+	m, err := jc.operate(ctx, "status", StatusParams{Detalhado: detailed})
+	if err != nil {
+		return client.ServerStatus{}, err
+	}
+	return client.DecodeStatus(m), nil
+}
 
-	default:
-		return fmt.Errorf("unknown operation: %s", op)
+func (jc *JsonClient) History(ctx context.Context, limit int) ([]client.HistoryEntry, error) {
+	m, err := jc.operate(ctx, "historico", HistoryParams{Limite: limit})
+	if err != nil {
+		return nil, err
 	}
+	return client.DecodeHistory(m), nil
+}
 
-	switch op {
-	case "sum":
-		op = "soma"
-	case "history":
-		op = "historico"
+// operate runs op with the saved session's token and returns the
+// "resultado" object of a successful reply.
+func (jc *JsonClient) operate(ctx context.Context, op string, params any) (result map[string]any, err error) {
+	ctx, span := trace.Start(ctx, "json.Operation", trace.String("protocol", "json"), trace.String("operation", op))
+	defer func() { span.End(err) }()
+
+	rec, err := auth.RequireLogin()
+	if err != nil {
+		return nil, err
 	}
 
 	body := Operation{
 		Type:      "operacao",
 		Operation: op,
-		Token:     token,
+		Token:     rec.Token,
 		Params:    params,
 	}
 
+	_, marshal := trace.Start(ctx, "marshal")
 	payload, err := json.Marshal(body)
+	marshal.End(err)
 	if err != nil {
-		return fmt.Errorf("marshal operation request: %w", err)
+		return nil, fmt.Errorf("marshal operation request: %w", err)
 	}
 
-	req := string(payload)
-	fmt.Printf("Sending request: %s\n", req)
+	raw, err := jc.send(ctx, op, string(payload))
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := tcp.Request(req, jc.Host, jc.Port)
+	var resp OperationResponse
+	_, unmarshal := trace.Start(ctx, "unmarshal")
+	err = json.Unmarshal(raw, &resp)
+	unmarshal.End(err)
+	if err != nil {
+		return nil, fmt.Errorf("decode operation response: %w", err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+func (jc *JsonClient) Ping(ctx context.Context) error {
+	rec, err := auth.RequireLogin()
 	if err != nil {
 		return err
 	}
+	payload, err := json.Marshal(Operation{
+		Type:      "operacao",
+		Operation: "timestamp",
+		Token:     rec.Token,
+		Params:    TimestampParams{},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal operation request: %w", err)
+	}
+	_, err = jc.send(ctx, "timestamp", string(payload))
+	return err
+}
+
+func (jc *JsonClient) send(ctx context.Context, op, req string) (json.RawMessage, error) {
+	ctx = tcp.WithOperation(ctx, tcp.Operation{Name: op, Idempotent: op != "logout"})
+	host, port := jc.Target()
+	jc.Log().DebugContext(ctx, "sending request", "request", tcp.Redact(req))
+	resp, err := jc.Transporter().RequestJSON(ctx, req, host, port)
+	if err != nil {
+		return nil, err
+	}
+	jc.Log().DebugContext(ctx, "received response", "response", tcp.Redact(string(resp)))
+	return resp, nil
+}
 
-	fmt.Printf("Received: %s\n", resp)
-	return nil
+// err reports a failed reply as a ServerError.
+func (r OperationResponse) err() error {
+	if r.Success {
+		return nil
+	}
+	msg := r.Error
+	if msg == "" {
+		msg = r.Message
+	}
+	return &client.ServerError{Code: r.Details.Code, Message: msg}
 }
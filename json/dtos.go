@@ -5,6 +5,18 @@ type Auth struct {
 	StudentId string `json:"aluno_id"`
 }
 
+type AuthResponse struct {
+	Success bool         `json:"sucesso"`
+	Token   string       `json:"token"`
+	Message string       `json:"mensagem"`
+	Error   string       `json:"erro"`
+	Details ErrorDetails `json:"detalhes"`
+}
+
+type ErrorDetails struct {
+	Code string `json:"codigo"`
+}
+
 type Logout struct {
 	Type  string `json:"tipo"`
 	Token string `json:"token"`
@@ -17,6 +29,14 @@ type Operation struct {
 	Params    any    `json:"parametros"`
 }
 
+type OperationResponse struct {
+	Success bool           `json:"sucesso"`
+	Result  map[string]any `json:"resultado"`
+	Message string         `json:"mensagem"`
+	Error   string         `json:"erro"`
+	Details ErrorDetails   `json:"detalhes"`
+}
+
 type EchoParams struct {
 	Message string `json:"mensagem"`
 }
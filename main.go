@@ -3,19 +3,29 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/erikbayerlein/mult-protocol-clients/client"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/auth"
-	jc "github.com/erikbayerlein/mult-protocol-clients/json"
-	pb "github.com/erikbayerlein/mult-protocol-clients/proto"
-	sc "github.com/erikbayerlein/mult-protocol-clients/strings"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/trace"
+	_ "github.com/erikbayerlein/mult-protocol-clients/json"
+	_ "github.com/erikbayerlein/mult-protocol-clients/proto"
+	_ "github.com/erikbayerlein/mult-protocol-clients/strings"
 )
 
 const usageText = `
@@ -25,6 +35,10 @@ Commands:
   login <client> <student_id>       Authenticate user to server and save
   whoami                            Show current logged user and client
   logout                            Logout and clear token
+  servers                           Show which address serves each protocol and its circuit state
+  metrics                           Show request, byte, error and latency counters
+  record <file> | off               Append wire traces to a JSONL file (tokens redacted)
+  replay <file> | off               Answer requests from a recorded trace, offline
   string <operation> [args...]      Run operation with string client
   json <operation> [args...]      	Run operation with json client
   proto <operation> [args...]       Run operation with protobuff client
@@ -39,30 +53,80 @@ Operations (client):
 `
 
 const (
-	host           = "3.88.99.255"
-	string_port    = 8080
-	json_port      = 8081
-	protobuff_port = 8082
+	defaultHost = "3.88.99.255"
+
+	// logoutProtocol signs out a session left over from an earlier run,
+	// when no client has been picked yet.
+	logoutProtocol = "proto"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+var (
+	host          = flag.String("host", defaultHost, "Server host name or address (IPv4 or IPv6)")
+	useTLS        = flag.Bool("tls", false, "Connect to the servers over TLS")
+	tlsCA         = flag.String("tls-ca", "", "PEM bundle of CAs trusted for the server certificates")
+	tlsCert       = flag.String("tls-cert", "", "Client certificate for mutual TLS")
+	tlsKey        = flag.String("tls-key", "", "Client private key for mutual TLS")
+	tlsServerName = flag.String("tls-server-name", "", "Override the SNI / verified server name")
+	tlsInsecure   = flag.Bool("tls-insecure", false, "Skip server certificate verification (labs only)")
+	proxyURL      = flag.String("proxy", "", "socks5://[user:pass@]host:port or http://host:port proxy (default: ALL_PROXY/HTTPS_PROXY)")
+	keepAlive     = flag.Duration("keepalive", 30*time.Second, "TCP keepalive period (negative disables)")
+	idleTimeout   = flag.Duration("idle-timeout", 5*time.Minute, "Reconnect when a connection has been idle this long (0 disables)")
+	traceFile     = flag.String("trace-file", "", "Append OTLP-JSON spans for every login, logout and operation to this file")
+	metricsAddr   = flag.String("metrics-addr", "", "Serve Prometheus metrics at http://<addr>/metrics (e.g. 127.0.0.1:9464)")
+	rate          = flag.Float64("rate", 0, "Limit requests per second to each server (0 = unlimited)")
+	burst         = flag.Int("burst", 1, "Requests allowed in a burst above -rate")
+	opRates       = flag.String("op-rate", "", "Per-operation limits in requests per second, e.g. soma=2,echo=5")
+	rateFailFast  = flag.Bool("rate-fail-fast", false, "Fail rate-limited requests instead of waiting")
+	breakerFails  = flag.Int("breaker-threshold", 5, "Consecutive failures that open a server's circuit (0 disables)")
+	breakerWait   = flag.Duration("breaker-cooldown", 30*time.Second, "How long an open circuit fails calls before probing the server again")
+	logLevel      = flag.String("log-level", "info", "Log level: debug (includes wire dumps), info, warn or error")
+	logFormat     = flag.String("log-format", "text", "Log format: text or json")
+	logFile       = flag.String("log-file", "", "Write logs to this file instead of stderr")
+	heartbeat     = flag.Duration("heartbeat", 0, "Send a timestamp request after this much idleness to keep the session alive (0 disables)")
 )
 
 var (
 	currentClient = ""
+	recordFile    *os.File
+	recorder      *tcp.Recorder
+	logOutput     *os.File
 
-	string_client = sc.StringClient{
-		Host: host,
-		Port: string_port,
-	}
+	logger *slog.Logger
+
+	baseTransport tcp.Transport = tcp.Default
+
+	clients       = map[string]client.Client{}
+	protocolFlags = defineProtocolFlags()
+)
 
-	json_client = jc.JsonClient{
-		Host: host,
-		Port: json_port,
+type endpointFlags struct {
+	fallback *string
+	endpoint *string
+}
+
+// defineProtocolFlags adds -<protocol>-fallback and -<protocol>-endpoint for
+// every registered protocol.
+func defineProtocolFlags() map[string]endpointFlags {
+	out := make(map[string]endpointFlags)
+	for _, p := range client.Protocols() {
+		out[p.Name] = endpointFlags{
+			fallback: flag.String(p.Name+"-fallback", "", "Comma-separated fallback hosts for the "+p.Title+" server"),
+			endpoint: flag.String(p.Name+"-endpoint", "", "Reach the "+p.Title+" server at unix:///path instead of -host"),
+		}
 	}
+	return out
+}
 
-	protobuff_client = pb.ProtobufClient{
-		Host: host,
-		Port: protobuff_port,
+func clientOptions(name string, t tcp.Transport) client.Options {
+	return client.Options{
+		Host:      *host,
+		Endpoint:  *protocolFlags[name].endpoint,
+		Transport: t,
+		Logger:    logger,
 	}
-)
+}
 
 func clearScreen() {
 	switch runtime.GOOS {
@@ -78,36 +142,339 @@ func clearScreen() {
 }
 
 func gracefulShutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
 	rec, err := auth.LoadToken()
 	if err == nil && rec.Token != "" {
+		if c, ok := clients[currentClient]; ok {
+			_ = c.Logout(ctx, rec.Token)
+		}
+		_ = auth.ClearToken()
+		fmt.Println("Logged out")
+	}
+	stopRecording()
+	_ = trace.SetExporter(nil)
+	if logOutput != nil {
+		_ = logOutput.Close()
+	}
+}
+
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("-log-level: %w", err)
+	}
+
+	var w io.Writer = os.Stderr
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("-log-file: %w", err)
+		}
+		logOutput = f
+		w = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch *logFormat {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("-log-format: unknown format %q", *logFormat)
+	}
+
+	logger = slog.New(h)
+	slog.SetDefault(logger)
+	tcp.Default.Logger = logger
+	return nil
+}
+
+func startTracing(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_ = trace.SetExporter(trace.NewFileExporter(f, "mult-protocol-clients"))
+	tcp.AddHooks(trace.TransportHooks())
+	return nil
+}
+
+func configureTransport() error {
+	cfg := tcp.DefaultConfig()
+	cfg.Proxy = *proxyURL
+	cfg.KeepAlive = *keepAlive
+	cfg.IdleTimeout = *idleTimeout
+	cfg.RateLimit = tcp.RateLimit{Rate: *rate, Burst: *burst}
+	cfg.RateLimitFailFast = *rateFailFast
+	cfg.BreakerThreshold = *breakerFails
+	cfg.BreakerCooldown = *breakerWait
+	if *opRates != "" {
+		cfg.OpRateLimits = make(map[string]tcp.RateLimit)
+		for _, item := range splitList(*opRates) {
+			op, value, _ := strings.Cut(item, "=")
+			r, err := strconv.ParseFloat(value, 64)
+			if err != nil || op == "" {
+				return fmt.Errorf("invalid -op-rate entry %q", item)
+			}
+			cfg.OpRateLimits[op] = tcp.RateLimit{Rate: r, Burst: *burst}
+		}
+	}
+	if *useTLS {
+		cfg.TLS = &tcp.TLSConfig{
+			CAFile:             *tlsCA,
+			CertFile:           *tlsCert,
+			KeyFile:            *tlsKey,
+			ServerName:         *tlsServerName,
+			InsecureSkipVerify: *tlsInsecure,
+		}
+	}
+	tcp.SetDefaultConfig(cfg)
+
+	for _, p := range client.Protocols() {
+		// Heartbeats keep the real connection alive, so they bypass any
+		// recorder or replay the REPL switches to later.
+		c, err := client.New(p.Name, clientOptions(p.Name, tcp.Default))
+		if err != nil {
+			return err
+		}
+		endpointCfg := cfg
+		endpointCfg.FallbackHosts = splitList(*protocolFlags[p.Name].fallback)
+		if *heartbeat > 0 {
+			endpointCfg.HeartbeatInterval = *heartbeat
+			endpointCfg.Heartbeat = c.Ping
+		}
+		h, port := c.Target()
+		tcp.Configure(h, port, endpointCfg)
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func printServers() {
+	for _, name := range client.Names() {
+		h, p := clients[name].Target()
+		peer := tcp.Default.Peer(h, p)
+		if peer == "" {
+			peer = "not connected"
+		}
+		breaker := tcp.Default.Breaker(h, p)
+		state := breaker.State.String()
+		switch breaker.State {
+		case tcp.BreakerOpen:
+			state += fmt.Sprintf(" (%d failures, retry in %s)", breaker.Failures, time.Until(breaker.RetryAt).Round(100*time.Millisecond))
+		case tcp.BreakerClosed:
+			if breaker.Failures > 0 {
+				state += fmt.Sprintf(" (%d recent failures)", breaker.Failures)
+			}
+		}
+		fmt.Printf("%-7s %s -> %s, circuit %s\n", name, tcp.EndpointAddress(h, p), peer, state)
+	}
+}
+
+func printMetrics() {
+	metrics := tcp.Default.Metrics()
+	if len(metrics) == 0 {
+		fmt.Println("No requests yet.")
+		return
+	}
+	fmt.Printf("%-28s %-7s %6s %6s %9s %9s %9s %9s %9s\n",
+		"Endpoint", "Proto", "Reqs", "Errs", "Sent (B)", "Recv (B)", "Dial avg", "RTT avg", "RTT p95")
+	for _, m := range metrics {
+		var errs uint64
+		for _, n := range m.Errors {
+			errs += n
+		}
+		fmt.Printf("%-28s %-7s %6d %6d %9d %9d %9s %9s %9s\n",
+			m.Endpoint, m.Protocol, m.Requests, errs, m.BytesSent, m.BytesReceived,
+			m.DialTime.Mean().Round(time.Microsecond), m.Latency.Mean().Round(time.Microsecond),
+			m.Latency.Quantile(0.95).Round(time.Microsecond))
+		for _, class := range slices.Sorted(maps.Keys(m.Errors)) {
+			fmt.Printf("    %s errors: %d\n", class, m.Errors[class])
+		}
+	}
+}
+
+func printResult(result any) {
+	switch r := result.(type) {
+	case client.EchoResult:
+		fmt.Printf("Echo: %s\n", r.Echo)
+		fmt.Printf("  original: %q (%d chars)\n", r.Original, r.Length)
+		if r.MD5 != "" {
+			fmt.Printf("  md5:      %s\n", r.MD5)
+		}
+		if r.ServerTime != "" {
+			fmt.Printf("  at:       %s\n", r.ServerTime)
+		}
 
-		switch currentClient {
-		case "string":
-			_ = string_client.Logout(rec.Token)
+	case client.SumResult:
+		fmt.Printf("Sum: %g\n", r.Sum)
+		fmt.Printf("  mean %g, min %g, max %g over %d numbers %v\n", r.Mean, r.Min, r.Max, r.Count, r.Numbers)
 
-		case "json":
-			_ = json_client.Logout(rec.Token)
+	case client.ServerTime:
+		fmt.Printf("Server time: %s\n", r.Formatted)
+		if r.Timezone != "" || r.Weekday != "" {
+			fmt.Printf("  %s, %s\n", r.Weekday, r.Timezone)
+		}
+		if r.Unix != 0 {
+			fmt.Printf("  unix: %.0f\n", r.Unix)
+		}
+		printDetails(r.Extra)
 
-		case "proto":
-			_ = protobuff_client.Logout(rec.Token)
+	case client.ServerStatus:
+		fmt.Printf("Status: %s\n", r.Status)
+		fmt.Printf("  operations processed: %d\n", r.Operations)
+		if r.Uptime != "" {
+			fmt.Printf("  uptime: %s\n", r.Uptime)
 		}
+		if r.ActiveSessions > 0 {
+			fmt.Printf("  active sessions: %d\n", r.ActiveSessions)
+		}
+		printDetails(r.Details)
 
-		_ = auth.ClearToken()
-		fmt.Println("Logged out")
+	case []client.HistoryEntry:
+		if len(r) == 0 {
+			fmt.Println("No operations yet.")
+		}
+		for i, e := range r {
+			outcome := "ok"
+			if !e.Success {
+				outcome = "failed"
+			}
+			fmt.Printf("%d. %s @ %s - %s", i+1, e.Operation, e.Time, outcome)
+			if e.Params != nil {
+				fmt.Printf(" %v", e.Params)
+			}
+			if e.Result != nil {
+				fmt.Printf(" -> %v", e.Result)
+			}
+			fmt.Println()
+		}
+
+	default:
+		fmt.Println("→", result)
+	}
+}
+
+func printDetails(details map[string]any) {
+	for _, k := range slices.Sorted(maps.Keys(details)) {
+		fmt.Printf("  %s: %v\n", k, details[k])
+	}
+}
+
+// applyTransport rebuilds the REPL's clients on the network, or on the
+// replay trace when one is loaded, wrapped in the recorder while recording.
+func applyTransport() {
+	t := baseTransport
+	if recorder != nil {
+		recorder.Next = baseTransport
+		t = recorder
+	}
+	for _, p := range client.Protocols() {
+		clients[p.Name], _ = client.New(p.Name, clientOptions(p.Name, t))
+	}
+}
+
+func startRecording(path string) error {
+	stopRecording()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	recorder = tcp.NewRecorder(baseTransport, f)
+	if tok, err := auth.LoadToken(); err == nil {
+		recorder.AddSecret(tok.Token)
+	}
+	recordFile = f
+	applyTransport()
+	return nil
+}
+
+func stopRecording() {
+	if recordFile == nil {
+		return
 	}
+	recorder = nil
+	applyTransport()
+	_ = recordFile.Close()
+	recordFile = nil
+}
+
+func startReplay(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	rp, err := tcp.NewReplay(f)
+	if err != nil {
+		return 0, err
+	}
+	baseTransport = rp
+	applyTransport()
+	return rp.Len(), nil
+}
+
+func stopReplay() {
+	baseTransport = tcp.Default
+	applyTransport()
 }
 
 func main() {
+	flag.Parse()
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	applyTransport()
+	if err := configureTransport(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *traceFile != "" {
+		if err := startTracing(*traceFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Trace file:", err)
+			os.Exit(1)
+		}
+	}
+	if *metricsAddr != "" {
+		if _, err := tcp.ServeMetrics(*metricsAddr, tcp.Default); err != nil {
+			fmt.Fprintln(os.Stderr, "Metrics server:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Serving metrics at http://%s/metrics\n", *metricsAddr)
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+
+	lines := make(chan string)
 	go func() {
-		<-ctx.Done()
-		fmt.Println()
-		gracefulShutdown()
-		os.Exit(0)
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
 	}()
 
-	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Go Multiprotocol Clients")
 	fmt.Println("(type 'help' for commands, 'exit' to quit)")
 	fmt.Print(usageText)
@@ -119,7 +486,19 @@ func main() {
 		}
 		fmt.Print("\n" + prompt)
 
-		line, _ := reader.ReadString('\n')
+		var line string
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			gracefulShutdown()
+			return
+		case l, ok := <-lines:
+			if !ok {
+				gracefulShutdown()
+				return
+			}
+			line = l
+		}
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
@@ -152,34 +531,56 @@ func main() {
 				continue
 			}
 
-			switch clientArg {
-			case "string":
-				if err := string_client.Login(studentID); err != nil {
-					fmt.Println("Login failed:", err)
-					continue
-				}
-				currentClient = clientArg
-				fmt.Printf("Logged in on %s server as student_id=%d\n", currentClient, studentID)
+			c, ok := clients[clientArg]
+			if !ok {
+				fmt.Printf("Invalid client: %s\nUse: %s\n", clientArg, strings.Join(client.Names(), " | "))
+				continue
+			}
+			if err := c.Login(ctx, studentID); err != nil {
+				fmt.Println("Login failed:", err)
+				continue
+			}
+			currentClient = clientArg
+			fmt.Printf("Logged in on %s server as student_id=%d\n", currentClient, studentID)
 
-			case "json":
-				if err := json_client.Login(studentID); err != nil {
-					fmt.Println("Login failed:", err)
-					continue
-				}
-				currentClient = clientArg
-				fmt.Printf("Logged in on %s server as student_id=%d\n", currentClient, studentID)
+		case "servers":
+			printServers()
 
-			case "proto":
-				if err := protobuff_client.Login(studentID); err != nil {
-					fmt.Println("Login failed:", err)
-					continue
-				}
-				currentClient = clientArg
-				fmt.Printf("Logged in on %s server as student_id=%d\n", currentClient, studentID)
+		case "metrics":
+			printMetrics()
+
+		case "record":
+			if len(args) < 1 {
+				fmt.Println("Usage: record <file> | off")
+				continue
+			}
+			if args[0] == "off" {
+				stopRecording()
+				fmt.Println("Recording stopped.")
+				continue
+			}
+			if err := startRecording(args[0]); err != nil {
+				fmt.Println("Record error:", err)
+				continue
+			}
+			fmt.Printf("Recording wire traffic to %s\n", args[0])
 
-			default:
-				fmt.Printf("Invalid client: %s\nUse: string | json | protobuff\n", clientArg)
+		case "replay":
+			if len(args) < 1 {
+				fmt.Println("Usage: replay <file> | off")
+				continue
+			}
+			if args[0] == "off" {
+				stopReplay()
+				fmt.Println("Replay stopped, using the network again.")
+				continue
 			}
+			n, err := startReplay(args[0])
+			if err != nil {
+				fmt.Println("Replay error:", err)
+				continue
+			}
+			fmt.Printf("Replaying %d recorded exchanges from %s\n", n, args[0])
 
 		case "whoami":
 			rec, err := auth.LoadToken()
@@ -197,49 +598,34 @@ func main() {
 				fmt.Println("You're not logged.")
 				continue
 			}
-			switch currentClient {
-			case "string":
-				if err := string_client.Logout(rec.Token); err != nil {
-					fmt.Println("Logout error:", err)
-				}
-			case "json":
-				if err := json_client.Logout(rec.Token); err != nil {
+			if c, ok := clients[currentClient]; ok {
+				if err := c.Logout(ctx, rec.Token); err != nil {
 					fmt.Println("Logout error:", err)
 				}
-			case "proto":
-				if err := protobuff_client.Logout(rec.Token); err != nil {
-					fmt.Println("Logout error:", err)
-				}
-			default:
-				_ = protobuff_client.Logout(rec.Token)
+			} else if c, ok := clients[logoutProtocol]; ok {
+				_ = c.Logout(ctx, rec.Token)
 			}
 			_ = auth.ClearToken()
 			currentClient = ""
 			fmt.Println("Logged out.")
 
 		default:
-			if currentClient != "" {
-				op := cmd
-				rest := args
-				switch currentClient {
-				case "string":
-					if err := string_client.Run(op, rest); err != nil {
-						fmt.Println("Error:", err)
-					}
-
-				case "json":
-					if err := json_client.Run(op, rest); err != nil {
-						fmt.Println("Error:", err)
-					}
-
-				case "proto":
-					if err := protobuff_client.Run(op, rest); err != nil {
-						fmt.Println("Error:", err)
+			op, rest := cmd, args
+			c, ok := clients[currentClient]
+			if named, isProtocol := clients[cmd]; isProtocol && len(args) > 0 {
+				c, ok = named, true
+				op, rest = args[0], args[1:]
+			}
+			if ok {
+				result, err := client.Do(ctx, c, op, rest)
+				if err != nil {
+					fmt.Println("Error:", err)
+					if errors.Is(err, client.ErrTokenExpired) {
+						fmt.Println("The server no longer accepts this session; log in again.")
 					}
-
-				default:
-					fmt.Println("Unknown command:", cmd)
+					continue
 				}
+				printResult(result)
 				continue
 			}
 
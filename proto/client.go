@@ -1,23 +1,39 @@
 package pbclient
 
 import (
+	"context"
 	"fmt"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/erikbayerlein/mult-protocol-clients/client"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/auth"
 	pb "github.com/erikbayerlein/mult-protocol-clients/internal/pb"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/trace"
 	"google.golang.org/protobuf/proto"
 )
 
 type ProtobufClient struct {
-	Host string
-	Port int
+	client.Options
 }
 
-func (pc *ProtobufClient) Login(studentId int) error {
+func init() {
+	client.Register(client.Protocol{
+		Name:        "proto",
+		Title:       "protobuf",
+		DefaultPort: 8082,
+		New: func(o client.Options) client.Client {
+			return &ProtobufClient{Options: o}
+		},
+	})
+}
+
+func (pc *ProtobufClient) Login(ctx context.Context, studentId int) (err error) {
+	ctx, span := trace.Start(ctx, "proto.Login", trace.String("protocol", "proto"), trace.String("operation", "auth"))
+	defer func() { span.End(err) }()
+
 	req := &pb.Requisicao{
 		Conteudo: &pb.Requisicao_Auth{
 			Auth: &pb.Auth{
@@ -27,20 +43,31 @@ func (pc *ProtobufClient) Login(studentId int) error {
 		},
 	}
 
+	_, marshal := trace.Start(ctx, "marshal")
 	payload, err := proto.Marshal(req)
+	marshal.End(err)
 	if err != nil {
 		return fmt.Errorf("serialization error: %w", err)
 	}
 
-	respBytes, err := tcp.RequestBytes(payload, pc.Host, pc.Port)
+	ctx = tcp.WithOperation(ctx, tcp.Operation{Name: "auth"})
+	host, port := pc.Target()
+	pc.Log().DebugContext(ctx, "sending request", "operation", "auth", "student_id", studentId, "bytes", len(payload))
+	respBytes, err := pc.Transporter().RequestBytes(ctx, payload, host, port)
 	if err != nil {
 		return fmt.Errorf("tcp auth error: %w", err)
 	}
 
 	var resp pb.Resposta
-	if err := proto.Unmarshal(respBytes, &resp); err != nil {
+	_, unmarshal := trace.Start(ctx, "unmarshal")
+	err = proto.Unmarshal(respBytes, &resp)
+	unmarshal.End(err)
+	if err != nil {
 		return fmt.Errorf("decode auth error: %w", err)
 	}
+	if err := serverError(&resp); err != nil {
+		return err
+	}
 
 	op := resp.GetOperacao()
 	if op == nil {
@@ -52,7 +79,7 @@ func (pc *ProtobufClient) Login(studentId int) error {
 		return fmt.Errorf("token not identified")
 	}
 
-	fmt.Println("Received token:", token)
+	pc.Log().DebugContext(ctx, "received token", "student_id", studentId)
 
 	if err := auth.SaveToken(auth.TokenRecord{StudentId: studentId, Token: token}); err != nil {
 		return fmt.Errorf("error saving token: %w", err)
@@ -60,72 +87,89 @@ func (pc *ProtobufClient) Login(studentId int) error {
 	return nil
 }
 
-func (pc *ProtobufClient) Logout(token string) error {
-	resp, err := pc.doOperation("logout", token, nil)
+func (pc *ProtobufClient) Logout(ctx context.Context, token string) (err error) {
+	ctx, span := trace.Start(ctx, "proto.Logout", trace.String("protocol", "proto"), trace.String("operation", "logout"))
+	defer func() { span.End(err) }()
+
+	_, err = pc.doOperation(ctx, "logout", token, nil)
+	return err
+}
+
+func (pc *ProtobufClient) Echo(ctx context.Context, message string) (client.EchoResult, error) {
+	m, err := pc.operate(ctx, "echo", map[string]string{"mensagem": message})
 	if err != nil {
-		return err
+		return client.EchoResult{}, err
 	}
-	fmt.Println("Logout:", resp)
-	return nil
+	return client.DecodeEcho(m), nil
 }
 
-func (pc *ProtobufClient) Run(op string, args []string) error {
-	rec, err := auth.RequireLogin()
+func (pc *ProtobufClient) Sum(ctx context.Context, nums []int) (client.SumResult, error) {
+	parts := make([]string, len(nums))
+	for i, n := range nums {
+		parts[i] = strconv.Itoa(n)
+	}
+	m, err := pc.operate(ctx, "soma", map[string]string{"numeros": strings.Join(parts, ",")})
 	if err != nil {
-		return err
+		return client.SumResult{}, err
 	}
-	token := rec.Token
-
-	switch op {
-	case "echo":
-		if len(args) < 1 {
-			return fmt.Errorf("echo needs a message")
-		}
-		resp, err := pc.doOperation("echo", token, map[string]string{
-			"mensagem": strings.Join(args, " "),
-		})
-		fmt.Println("→", resp)
-		return err
+	return client.DecodeSum(m), nil
+}
 
-	case "sum":
-		if len(args) < 1 {
-			return fmt.Errorf("sum needs a list of nums, ex: 1,2,3")
-		}
-		resp, err := pc.doOperation("soma", token, map[string]string{
-			"numeros": args[0],
-		})
-		fmt.Println("→", resp)
-		return err
+func (pc *ProtobufClient) Timestamp(ctx context.Context) (client.ServerTime, error) {
+	m, err := pc.operate(ctx, "timestamp", nil)
+	if err != nil {
+		return client.ServerTime{}, err
+	}
+	return client.DecodeTime(m), nil
+}
 
-	case "timestamp":
-		resp, err := pc.doOperation("timestamp", token, nil)
-		fmt.Println("→", resp)
-		return err
+func (pc *ProtobufClient) Status(ctx context.Context, detailed bool) (client.ServerStatus, error) {
+	m, err := pc.operate(ctx, "status", map[string]string{"detalhado": strconv.FormatBool(detailed)})
+	if err != nil {
+		return client.ServerStatus{}, err
+	}
+	return client.DecodeStatus(m), nil
+}
 
-	case "status":
-		resp, err := pc.doOperation("status", token, map[string]string{
-			"detalhado": "true",
-		})
-		fmt.Println("→", resp)
-		return err
+func (pc *ProtobufClient) History(ctx context.Context, limit int) ([]client.HistoryEntry, error) {
+	m, err := pc.operate(ctx, "historico", map[string]string{"limite": strconv.Itoa(limit)})
+	if err != nil {
+		return nil, err
+	}
+	return client.DecodeHistory(m), nil
+}
 
-	case "history", "historico":
-		limit := "10"
-		if len(args) >= 1 {
-			limit = args[0]
-		}
-		resp, err := pc.doOperation("historico", token, map[string]string{
-			"limite": limit,
-		})
-		fmt.Println("→", resp)
-		return err
+// operate runs op with the saved session's token and returns the resultado
+// map of a successful reply.
+func (pc *ProtobufClient) operate(ctx context.Context, op string, params map[string]string) (result map[string]any, err error) {
+	ctx, span := trace.Start(ctx, "proto.Operation", trace.String("protocol", "proto"), trace.String("operation", op))
+	defer func() { span.End(err) }()
 
-	default:
-		return fmt.Errorf("unknown operation: %s", op)
+	rec, err := auth.RequireLogin()
+	if err != nil {
+		return nil, err
 	}
+	resp, err := pc.doOperation(ctx, op, rec.Token, params)
+	if err != nil {
+		return nil, err
+	}
+	result = make(map[string]any, len(resp.GetResultado()))
+	for k, v := range resp.GetResultado() {
+		result[k] = v
+	}
+	return result, nil
 }
 
-func (pc *ProtobufClient) doOperation(nomeOperacao, token string, params map[string]string) (string, error) {
+func (pc *ProtobufClient) Ping(ctx context.Context) error {
+	rec, err := auth.RequireLogin()
+	if err != nil {
+		return err
+	}
+	_, err = pc.doOperation(ctx, "timestamp", rec.Token, nil)
+	return err
+}
+
+func (pc *ProtobufClient) doOperation(ctx context.Context, nomeOperacao, token string, params map[string]string) (*pb.OperacaoResponse, error) {
 	req := &pb.Requisicao{
 		Conteudo: &pb.Requisicao_Operacao{
 			Operacao: &pb.Operacao{
@@ -137,56 +181,60 @@ func (pc *ProtobufClient) doOperation(nomeOperacao, token string, params map[str
 		},
 	}
 
+	_, marshal := trace.Start(ctx, "marshal")
 	payload, err := proto.Marshal(req)
+	marshal.End(err)
 	if err != nil {
-		return "", fmt.Errorf("serialization error: %w", err)
+		return nil, fmt.Errorf("serialization error: %w", err)
 	}
 
-	respBytes, err := tcp.RequestBytes(payload, pc.Host, pc.Port)
+	ctx = tcp.WithOperation(ctx, tcp.Operation{
+		Name:       nomeOperacao,
+		Idempotent: nomeOperacao != "logout",
+	})
+	host, port := pc.Target()
+	pc.Log().DebugContext(ctx, "sending request", "operation", nomeOperacao, "params", params, "bytes", len(payload))
+	respBytes, err := pc.Transporter().RequestBytes(ctx, payload, host, port)
 	if err != nil {
-		return "", fmt.Errorf("tcp error: %w", err)
+		return nil, fmt.Errorf("tcp error: %w", err)
 	}
 
 	var resp pb.Resposta
-	if err := proto.Unmarshal(respBytes, &resp); err != nil {
-		return "", fmt.Errorf("decode response error: %w", err)
+	_, unmarshal := trace.Start(ctx, "unmarshal")
+	err = proto.Unmarshal(respBytes, &resp)
+	unmarshal.End(err)
+	if err != nil {
+		return nil, fmt.Errorf("decode response error: %w", err)
 	}
 
 	op := resp.GetOperacao()
+	pc.Log().DebugContext(ctx, "received response", "operation", nomeOperacao,
+		"sucesso", op.GetSucesso(), "resultado", op.GetResultado(), "bytes", len(respBytes))
+	if err := serverError(&resp); err != nil {
+		return nil, err
+	}
 	if op == nil && nomeOperacao != "logout" {
-		return "", fmt.Errorf("invalid response")
+		return nil, fmt.Errorf("invalid response")
 	}
 
-	return formatResultado(nomeOperacao, op), nil
+	return op, nil
 }
 
-func formatResultado(cmd string, op *pb.OperacaoResponse) string {
-	if op == nil {
-		return "ok"
-	}
-
-	var b strings.Builder
-	b.WriteString("ok {\n")
-	b.WriteString(fmt.Sprintf(`  comando: "%s"`+"\n", strings.ToUpper(cmd)))
-
-	keys := make([]string, 0, len(op.Resultado))
-	for k := range op.Resultado {
-		keys = append(keys, k)
+// serverError reports a failed reply as a ServerError: a RespostaErro, or an
+// OperacaoResponse with sucesso=false.
+func serverError(resp *pb.Resposta) error {
+	if e := resp.GetErro(); e != nil {
+		return &client.ServerError{Code: e.GetCodigo(), Message: e.GetMensagem()}
 	}
-	sort.Strings(keys)
 
-	for _, k := range keys {
-		v := op.Resultado[k]
-		b.WriteString("  dados {\n")
-		b.WriteString(fmt.Sprintf("    key: %q\n", k))
-		b.WriteString(fmt.Sprintf("    value: %q\n", v))
-		b.WriteString("  }\n")
+	op := resp.GetOperacao()
+	if op == nil || op.GetSucesso() {
+		return nil
 	}
-
-	if op.Timestamp != "" {
-		b.WriteString(fmt.Sprintf("  timestamp: %q\n", op.Timestamp))
+	r := op.GetResultado()
+	msg := r["erro"]
+	if msg == "" {
+		msg = r["mensagem"]
 	}
-	b.WriteString("}")
-
-	return b.String()
+	return &client.ServerError{Code: r["codigo"], Message: msg}
 }
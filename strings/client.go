@@ -1,95 +1,172 @@
 package strings
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 
+	"github.com/erikbayerlein/mult-protocol-clients/client"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/auth"
 	"github.com/erikbayerlein/mult-protocol-clients/internal/tcp"
+	"github.com/erikbayerlein/mult-protocol-clients/internal/trace"
 )
 
 type StringClient struct {
-	Host string
-	Port int
+	client.Options
 }
 
-func (sc *StringClient) Login(studentId int) error {
+func init() {
+	client.Register(client.Protocol{
+		Name:        "string",
+		Title:       "string",
+		DefaultPort: 8080,
+		New: func(o client.Options) client.Client {
+			return &StringClient{Options: o}
+		},
+	})
+}
+
+func (sc *StringClient) Login(ctx context.Context, studentId int) (err error) {
+	ctx, span := trace.Start(ctx, "string.Login", trace.String("protocol", "string"), trace.String("operation", "auth"))
+	defer func() { span.End(err) }()
+
+	_, marshal := trace.Start(ctx, "marshal")
 	authRequest := fmt.Sprintf("AUTH|aluno_id=%d|FIM", studentId)
-	token, err := auth.Auth(authRequest, sc.Host, sc.Port)
+	marshal.End(nil)
+	resp, err := sc.exchange(tcp.WithOperation(ctx, tcp.Operation{Name: "auth"}), authRequest)
 	if err != nil {
 		return err
 	}
-	if err := auth.SaveToken(auth.TokenRecord{StudentId: studentId, Token: token}); err != nil {
-		fmt.Println("Could not save token:", err)
+
+	_, unmarshal := trace.Start(ctx, "unmarshal")
+	token, err := parseToken(resp)
+	unmarshal.End(err)
+	if err != nil {
 		return err
 	}
+	if err := auth.SaveToken(auth.TokenRecord{StudentId: studentId, Token: token}); err != nil {
+		return fmt.Errorf("could not save token: %w", err)
+	}
 	return nil
 }
 
-func (sc *StringClient) Run(op string, args []string) error {
+func (sc *StringClient) Echo(ctx context.Context, message string) (client.EchoResult, error) {
+	m, err := sc.operate(ctx, "echo", map[string]any{"mensagem": message})
+	if err != nil {
+		return client.EchoResult{}, err
+	}
+	return client.DecodeEcho(m), nil
+}
+
+func (sc *StringClient) Sum(ctx context.Context, nums []int) (client.SumResult, error) {
+	m, err := sc.operate(ctx, "soma", map[string]any{"nums": nums})
+	if err != nil {
+		return client.SumResult{}, err
+	}
+	return client.DecodeSum(m), nil
+}
+
+func (sc *StringClient) Timestamp(ctx context.Context) (client.ServerTime, error) {
+	m, err := sc.operate(ctx, "timestamp", map[string]any{})
+	if err != nil {
+		return client.ServerTime{}, err
+	}
+	return client.DecodeTime(m), nil
+}
+
+func (sc *StringClient) Status(ctx context.Context, detailed bool) (client.ServerStatus, error) {
+	m, err := sc.operate(ctx, "status", map[string]any{"detalhado": detailed})
+	if err != nil {
+		return client.ServerStatus{}, err
+	}
+	return client.DecodeStatus(m), nil
+}
+
+func (sc *StringClient) History(ctx context.Context, limit int) ([]client.HistoryEntry, error) {
+	m, err := sc.operate(ctx, "historico", map[string]any{"limite": limit})
+	if err != nil {
+		return nil, err
+	}
+	return client.DecodeHistory(m), nil
+}
+
+// operate runs op with the saved session's token and returns the key/value
+// pairs of an OK reply.
+func (sc *StringClient) operate(ctx context.Context, op string, params map[string]any) (result map[string]any, err error) {
+	ctx, span := trace.Start(ctx, "string.Operation", trace.String("protocol", "string"), trace.String("operation", op))
+	defer func() { span.End(err) }()
+
 	rec, err := auth.RequireLogin()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	resp, err := sc.DoOperation(ctx, op, rec.Token, params)
+	if err != nil {
+		return nil, err
 	}
-	token := rec.Token
-	fmt.Println(token)
 
-	switch op {
-	case "echo":
-		if len(args) < 1 {
-			return fmt.Errorf("echo requires a message")
-		}
-		resp, err := sc.DoOperation("echo", token, map[string]any{"mensagem": strings.Join(args, " ")})
-		fmt.Println("→", resp)
-		return err
+	_, unmarshal := trace.Start(ctx, "unmarshal")
+	reply, err := ParseReply(resp)
+	if err == nil {
+		err = reply.Err()
+	}
+	unmarshal.End(err)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Map(), nil
+}
 
-	case "sum":
-		if len(args) < 1 {
-			return fmt.Errorf("sum requires a comma-separated list")
-		}
-		parts := strings.Split(args[0], ",")
-		ints := make([]int, 0, len(parts))
-		for _, p := range parts {
-			n, _ := strconv.Atoi(strings.TrimSpace(p))
-			ints = append(ints, n)
-		}
-		resp, err := sc.DoOperation("soma", token, map[string]any{"nums": ints})
-		fmt.Println("→", resp)
-		return err
+func (sc *StringClient) Logout(ctx context.Context, token string) (err error) {
+	ctx, span := trace.Start(ctx, "string.Logout", trace.String("protocol", "string"), trace.String("operation", "logout"))
+	defer func() { span.End(err) }()
 
-	case "timestamp":
-		resp, err := sc.DoOperation("timestamp", token, map[string]any{})
-		fmt.Println("→", resp)
+	_, marshal := trace.Start(ctx, "marshal")
+	req := fmt.Sprintf("LOGOUT|token=%s|FIM", token)
+	marshal.End(nil)
+	resp, err := sc.exchange(tcp.WithOperation(ctx, tcp.Operation{Name: "logout"}), req)
+	host, port := sc.Target()
+	sc.Transporter().CloseEndpoint(host, port)
+	if err != nil {
 		return err
+	}
 
-	case "status":
-		resp, err := sc.DoOperation("status", token, map[string]any{"detalhado": true})
-		fmt.Println("→", resp)
+	reply, err := ParseReply(resp)
+	if err != nil {
 		return err
+	}
+	return reply.Err()
+}
 
-	case "history":
-		limit := 10
-		if len(args) >= 1 {
-			if v, convErr := strconv.Atoi(args[0]); convErr == nil {
-				limit = v
-			}
+// parseToken reads the token from an AUTH reply. Some servers answer AUTH on
+// this port with a JSON object, which is accepted too.
+func parseToken(resp string) (string, error) {
+	if strings.HasPrefix(resp, "{") {
+		var j struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal([]byte(resp), &j); err == nil && j.Token != "" {
+			return j.Token, nil
 		}
-		resp, err := sc.DoOperation("historico", token, map[string]any{"limite": limit})
-		fmt.Println("→", resp)
-		return err
-
-	default:
-		return fmt.Errorf("unknown operation: %s", op)
 	}
-}
 
-func (sc *StringClient) Logout(token string) error {
-	req := fmt.Sprintf("LOGOUT|token=%s|FIM", token)
-	return auth.LogoutRemote(req, sc.Host, sc.Port)
+	reply, err := ParseReply(resp)
+	if err != nil {
+		return "", err
+	}
+	if err := reply.Err(); err != nil {
+		return "", err
+	}
+	token, ok := reply.Get("token")
+	if !ok || token == "" {
+		return "", fmt.Errorf("token not found in response")
+	}
+	return token, nil
 }
 
-func (sc *StringClient) DoOperation(op, token string, params map[string]any) (string, error) {
+func (sc *StringClient) DoOperation(ctx context.Context, op, token string, params map[string]any) (string, error) {
+	_, marshal := trace.Start(ctx, "marshal")
 	args := []string{"OP", "token=" + token, "operacao=" + op}
 	for key, value := range params {
 		switch v := value.(type) {
@@ -106,6 +183,31 @@ func (sc *StringClient) DoOperation(op, token string, params map[string]any) (st
 	args = append(args, "FIM")
 
 	message := strings.Join(args, "|")
-	fmt.Println(message)
-	return tcp.Request(message, sc.Host, sc.Port)
+	marshal.End(nil)
+	return sc.send(ctx, op, message)
+}
+
+func (sc *StringClient) Ping(ctx context.Context) error {
+	rec, err := auth.RequireLogin()
+	if err != nil {
+		return err
+	}
+	_, err = sc.send(ctx, "timestamp", fmt.Sprintf("OP|token=%s|operacao=timestamp|FIM", rec.Token))
+	return err
+}
+
+func (sc *StringClient) send(ctx context.Context, op, message string) (string, error) {
+	return sc.exchange(tcp.WithOperation(ctx, tcp.Operation{Name: op, Idempotent: op != "logout"}), message)
+}
+
+// exchange sends message under the operation already set in ctx.
+func (sc *StringClient) exchange(ctx context.Context, message string) (string, error) {
+	host, port := sc.Target()
+	sc.Log().DebugContext(ctx, "sending request", "request", tcp.Redact(message))
+	resp, err := sc.Transporter().Request(ctx, message, host, port)
+	if err != nil {
+		return "", err
+	}
+	sc.Log().DebugContext(ctx, "received response", "response", tcp.Redact(resp))
+	return resp, nil
 }
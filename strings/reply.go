@@ -0,0 +1,179 @@
+package strings
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/erikbayerlein/mult-protocol-clients/client"
+)
+
+var ErrMalformedReply = errors.New("malformed string reply")
+
+const terminator = "FIM"
+
+// Reply is a decoded "STATUS|key=value|...|FIM" reply. Fields keep the order
+// the server sent them in; segments without a key, such as the text of
+// "OK|Logout realizado|FIM", are kept in Text.
+type Reply struct {
+	Status string
+	Fields []Field
+	Text   []string
+}
+
+type Field struct {
+	Key   string
+	Value string
+}
+
+// ParseReply decodes one string-protocol reply. Segments are separated by
+// '|' and split into key and value at the first '='. "\|", "\=" and "\\"
+// stand for the character after the backslash; any other backslash is kept
+// as it is, so paths such as C:\new\dir come through unchanged. The reply
+// must end with FIM.
+func ParseReply(s string) (*Reply, error) {
+	segments, err := splitSegments(strings.TrimRight(s, "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("%w: %q has no status and FIM", ErrMalformedReply, s)
+	}
+
+	last := segments[len(segments)-1]
+	if last.hasKey || last.value != terminator {
+		return nil, fmt.Errorf("%w: missing %s terminator", ErrMalformedReply, terminator)
+	}
+	status := segments[0]
+	if status.hasKey || strings.TrimSpace(status.value) == "" {
+		return nil, fmt.Errorf("%w: missing status", ErrMalformedReply)
+	}
+
+	r := &Reply{Status: strings.TrimSpace(status.value)}
+	for _, seg := range segments[1 : len(segments)-1] {
+		switch {
+		case seg.hasKey:
+			key := strings.TrimSpace(seg.key)
+			if key == "" {
+				return nil, fmt.Errorf("%w: empty key", ErrMalformedReply)
+			}
+			r.Fields = append(r.Fields, Field{Key: key, Value: seg.value})
+		case seg.value == terminator:
+			return nil, fmt.Errorf("%w: data after %s", ErrMalformedReply, terminator)
+		case seg.value != "":
+			r.Text = append(r.Text, seg.value)
+		}
+	}
+	return r, nil
+}
+
+type segment struct {
+	key    string
+	value  string
+	hasKey bool
+}
+
+// splitSegments splits s at unescaped '|' and each segment at its first
+// unescaped '=', resolving escapes as it goes.
+func splitSegments(s string) ([]segment, error) {
+	var (
+		out []segment
+		cur segment
+		buf strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("%w: trailing backslash", ErrMalformedReply)
+			}
+			i++
+			if s[i] != '|' && s[i] != '=' && s[i] != '\\' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(s[i])
+		case c == '=' && !cur.hasKey:
+			cur.key = buf.String()
+			cur.hasKey = true
+			buf.Reset()
+		case c == '|':
+			cur.value = buf.String()
+			out = append(out, cur)
+			cur = segment{}
+			buf.Reset()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	cur.value = buf.String()
+	return append(out, cur), nil
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `=`, `\=`)
+
+// String encodes r back into wire form, fields first and then text, with
+// the escapes ParseReply undoes.
+func (r *Reply) String() string {
+	var b strings.Builder
+	b.WriteString(escaper.Replace(r.Status))
+	for _, f := range r.Fields {
+		b.WriteByte('|')
+		b.WriteString(escaper.Replace(f.Key))
+		b.WriteByte('=')
+		b.WriteString(escaper.Replace(f.Value))
+	}
+	for _, t := range r.Text {
+		b.WriteByte('|')
+		b.WriteString(escaper.Replace(t))
+	}
+	b.WriteString("|" + terminator)
+	return b.String()
+}
+
+// Get returns the value of the first field named key.
+func (r *Reply) Get(key string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Map returns the fields keyed by name, first occurrence winning, in the
+// form the client package's decoders take. List values such as "1,2,3" or
+// "[1, 2, 3]" are left as text for those decoders to split.
+func (r *Reply) Map() map[string]any {
+	m := make(map[string]any, len(r.Fields))
+	for _, f := range r.Fields {
+		if _, dup := m[f.Key]; !dup {
+			m[f.Key] = f.Value
+		}
+	}
+	return m
+}
+
+// Err reports an ERROR reply as a ServerError, and any status other than OK
+// as malformed.
+func (r *Reply) Err() error {
+	switch r.Status {
+	case "OK":
+		return nil
+	case "ERROR":
+		e := &client.ServerError{}
+		e.Code, _ = r.Get("codigo")
+		for _, key := range []string{"msg", "mensagem", "erro"} {
+			if v, ok := r.Get(key); ok && v != "" {
+				e.Message = v
+				break
+			}
+		}
+		if e.Message == "" {
+			e.Message = strings.Join(r.Text, "; ")
+		}
+		return e
+	default:
+		return fmt.Errorf("%w: unexpected status %q", ErrMalformedReply, r.Status)
+	}
+}
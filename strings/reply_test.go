@@ -0,0 +1,203 @@
+package strings
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/erikbayerlein/mult-protocol-clients/client"
+)
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    *Reply
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			in:   "OK|token=abc|FIM",
+			want: &Reply{Status: "OK", Fields: []Field{{"token", "abc"}}},
+		},
+		{
+			name: "error",
+			in:   "ERROR|msg=Token inválido|codigo=ERRO_TOKEN|FIM",
+			want: &Reply{Status: "ERROR", Fields: []Field{{"msg", "Token inválido"}, {"codigo", "ERRO_TOKEN"}}},
+		},
+		{
+			name: "line ending",
+			in:   "OK|a=1|FIM\r\n",
+			want: &Reply{Status: "OK", Fields: []Field{{"a", "1"}}},
+		},
+		{
+			name: "fields keep order",
+			in:   "OK|b=2|a=1|b=3|FIM",
+			want: &Reply{Status: "OK", Fields: []Field{{"b", "2"}, {"a", "1"}, {"b", "3"}}},
+		},
+		{
+			name: "value containing equals",
+			in:   "OK|mensagem_eco=a=b==c|FIM",
+			want: &Reply{Status: "OK", Fields: []Field{{"mensagem_eco", "a=b==c"}}},
+		},
+		{
+			name: "empty value",
+			in:   "OK|a=|FIM",
+			want: &Reply{Status: "OK", Fields: []Field{{"a", ""}}},
+		},
+		{
+			name: "keyless text",
+			in:   "OK|Logout realizado|FIM",
+			want: &Reply{Status: "OK", Text: []string{"Logout realizado"}},
+		},
+		{
+			name: "text and fields",
+			in:   "ERROR|Falha|codigo=X||FIM",
+			want: &Reply{Status: "ERROR", Fields: []Field{{"codigo", "X"}}, Text: []string{"Falha"}},
+		},
+		{
+			name: "escaped pipe",
+			in:   `OK|mensagem_eco=a\|b|FIM`,
+			want: &Reply{Status: "OK", Fields: []Field{{"mensagem_eco", "a|b"}}},
+		},
+		{
+			name: "escaped equals in key",
+			in:   `OK|a\=b=c|FIM`,
+			want: &Reply{Status: "OK", Fields: []Field{{"a=b", "c"}}},
+		},
+		{
+			name: "escaped equals makes text",
+			in:   `OK|a\=b|FIM`,
+			want: &Reply{Status: "OK", Text: []string{"a=b"}},
+		},
+		{
+			name: "escaped backslash",
+			in:   `OK|path=C:\\dir\\|FIM`,
+			want: &Reply{Status: "OK", Fields: []Field{{"path", `C:\dir\`}}},
+		},
+		{
+			name: "backslash n kept",
+			in:   `OK|mensagem_eco=C:\new\dir|FIM`,
+			want: &Reply{Status: "OK", Fields: []Field{{"mensagem_eco", `C:\new\dir`}}},
+		},
+		{
+			name: "escaped FIM is a value",
+			in:   `OK|a=\|FIM|FIM`,
+			want: &Reply{Status: "OK", Fields: []Field{{"a", "|FIM"}}},
+		},
+		{
+			name: "bracketed list",
+			in:   "OK|numeros_processados=[1, 2, 3]|FIM",
+			want: &Reply{Status: "OK", Fields: []Field{{"numeros_processados", "[1, 2, 3]"}}},
+		},
+		{
+			name: "quoted list",
+			in:   `OK|itens=['a\|b', "c=d"]|FIM`,
+			want: &Reply{Status: "OK", Fields: []Field{{"itens", `['a|b', "c=d"]`}}},
+		},
+		{name: "trailing backslash", in: `OK|a=b|FIM\`, wantErr: true},
+		{name: "backslash before end", in: `OK|a=b\`, wantErr: true},
+		{name: "missing FIM", in: "OK|a=b", wantErr: true},
+		{name: "escaped FIM", in: `OK|a=b|\FIM`, wantErr: true},
+		{name: "FIM with key", in: "OK|a=b|x=FIM", wantErr: true},
+		{name: "data after FIM", in: "OK|FIM|a=b|FIM", wantErr: true},
+		{name: "only FIM", in: "FIM", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+		{name: "empty status", in: " |a=b|FIM", wantErr: true},
+		{name: "status with key", in: "OK=1|FIM", wantErr: true},
+		{name: "empty key", in: "OK|=b|FIM", wantErr: true},
+		{name: "blank key", in: "OK| =b|FIM", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReply(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrMalformedReply) {
+					t.Fatalf("ParseReply(%q) = %+v, %v; want ErrMalformedReply", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseReply(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseReply(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyErr(t *testing.T) {
+	r, err := ParseReply("ERROR|msg=Token inválido|codigo=ERRO_TOKEN|FIM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Err()
+	if !errors.Is(err, client.ErrTokenExpired) || !errors.Is(err, client.ErrServer) {
+		t.Errorf("Err() = %v, want a ServerError matching ErrTokenExpired", err)
+	}
+
+	r, _ = ParseReply("OK|a=1|FIM")
+	if err := r.Err(); err != nil {
+		t.Errorf("Err() on OK = %v", err)
+	}
+	r, _ = ParseReply("MAYBE|FIM")
+	if err := r.Err(); !errors.Is(err, ErrMalformedReply) {
+		t.Errorf("Err() on unknown status = %v, want ErrMalformedReply", err)
+	}
+}
+
+func TestReplyMapDecodesLists(t *testing.T) {
+	for _, in := range []string{
+		"OK|numeros_processados=1,2,3|soma=6|FIM",
+		"OK|numeros_processados=[1, 2, 3]|soma=6|FIM",
+	} {
+		r, err := ParseReply(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := client.DecodeSum(r.Map())
+		if !reflect.DeepEqual(got.Numbers, []float64{1, 2, 3}) || got.Sum != 6 {
+			t.Errorf("DecodeSum(%q) = %+v", in, got)
+		}
+	}
+}
+
+func TestReplyStringRoundTrip(t *testing.T) {
+	fields := []Field{{"mensagem", `a|b=c\d C:\new`}, {"k=|", "FIM"}}
+	r := &Reply{Status: "OK", Fields: fields}
+	got, err := ParseReply(r.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Fields, fields) {
+		t.Errorf("round trip = %+v, want %+v", got.Fields, fields)
+	}
+}
+
+func FuzzParseReply(f *testing.F) {
+	for _, s := range []string{
+		"OK|token=abc|FIM",
+		"ERROR|msg=x|codigo=ERRO_TOKEN|FIM",
+		"OK|Logout realizado|FIM",
+		`OK|a\|b=c\=d\\e|FIM`,
+		`OK|path=C:\new\dir|FIM`,
+		"OK|numeros=[1, 2, 3]|FIM\r\n",
+		`OK|a=b|FIM\`,
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := ParseReply(s)
+		if err != nil {
+			return
+		}
+		again, err := ParseReply(r.String())
+		if err != nil {
+			t.Fatalf("ParseReply(%q) accepted, but its encoding %q was rejected: %v", s, r.String(), err)
+		}
+		if !reflect.DeepEqual(r, again) {
+			t.Fatalf("round trip of %q: %+v != %+v", s, r, again)
+		}
+	})
+}